@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ATFinalResult type, the final result code which terminates a command
+type ATFinalResult string
+
+const (
+	// ATResultOk the command succeeded
+	ATResultOk ATFinalResult = "OK"
+	// ATResultConnect the modem switched to data mode
+	ATResultConnect ATFinalResult = "CONNECT"
+	// ATResultError the command failed without further information
+	ATResultError ATFinalResult = "ERROR"
+	// ATResultCMEError the command failed with an equipment error
+	ATResultCMEError ATFinalResult = "+CME ERROR"
+	// ATResultCMSError the command failed with a message service error
+	ATResultCMSError ATFinalResult = "+CMS ERROR"
+	// ATResultNoCarrier the connection could not be established or was lost
+	ATResultNoCarrier ATFinalResult = "NO CARRIER"
+	// ATResultBusy the remote side is busy
+	ATResultBusy ATFinalResult = "BUSY"
+	// ATResultNoAnswer the remote side did not answer
+	ATResultNoAnswer ATFinalResult = "NO ANSWER"
+)
+
+// ErrCommandCancelled the command is cancelled
+var ErrCommandCancelled = errors.New("Cancelled")
+
+// ATResponse structure holding the intermediate lines and the final result of a command
+type ATResponse struct {
+	Command string
+	Lines   []string
+	Result  ATFinalResult
+	// Final is the complete final result line, for example: +CME ERROR: SIM not inserted
+	Final string
+}
+
+// ATError is returned when the modem terminated a command with an error result code
+type ATError struct {
+	Command string
+	Result  ATFinalResult
+	Text    string
+}
+
+func (atError *ATError) Error() string {
+
+	if atError.Text == "" {
+		return fmt.Sprintf("%v: %v", atError.Command, atError.Result)
+	}
+
+	return fmt.Sprintf("%v: %v: %v", atError.Command, atError.Result, atError.Text)
+}
+
+// Err returns an ATError unless the command succeeded
+func (response *ATResponse) Err() error {
+
+	switch response.Result {
+	case ATResultOk, ATResultConnect:
+		return nil
+	case ATResultError:
+		return &ATError{Command: response.Command, Result: response.Result, Text: "unspecified error (try to set error format for more information)"}
+	}
+
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(response.Final, string(response.Result)), ":"))
+
+	return &ATError{Command: response.Command, Result: response.Result, Text: text}
+}
+
+// Prefixed returns the intermediate lines with the prefix, the prefix and leading spaces are stripped
+func (response *ATResponse) Prefixed(prefix string) []string {
+
+	var values []string
+
+	for _, line := range response.Lines {
+		if strings.HasPrefix(line, prefix) {
+			values = append(values, strings.TrimSpace(strings.TrimPrefix(line, prefix)))
+		}
+	}
+
+	return values
+}
+
+// First returns the first intermediate line with the prefix, the prefix and leading spaces are stripped.
+// A response without the line is malformed, the next command is not affected.
+func (response *ATResponse) First(prefix string) (string, error) {
+
+	values := response.Prefixed(prefix)
+
+	if len(values) == 0 {
+		return "", &atParseError{text: fmt.Sprintf("%v: no %v line in response", response.Command, prefix)}
+	}
+
+	return values[0], nil
+}
+
+// parseATFinalResult returns the final result code of the line, ok is false for an intermediate line
+func parseATFinalResult(line string) (result ATFinalResult, ok bool) {
+
+	for _, result := range []ATFinalResult{ATResultOk, ATResultError, ATResultNoCarrier, ATResultBusy, ATResultNoAnswer} {
+		if line == string(result) {
+			return result, true
+		}
+	}
+
+	// CONNECT may be followed by the connection speed
+	if line == string(ATResultConnect) || strings.HasPrefix(line, string(ATResultConnect)+" ") {
+		return ATResultConnect, true
+	}
+
+	for _, result := range []ATFinalResult{ATResultCMEError, ATResultCMSError} {
+		if strings.HasPrefix(line, string(result)+":") {
+			return result, true
+		}
+	}
+
+	return "", false
+}
+
+// AtCommandHandler structure, a reader goroutine passes the lines to the pending command or the unsolicited result code handlers
+type AtCommandHandler struct {
+	writer  io.Writer
+	logger  *Logger
+	timeout time.Duration
+	// dirty is set when a command did not complete, its response may still arrive
+	dirty bool
+	// err is the read error, it's set before closed is closed
+	err    error
+	closed chan struct{}
+
+	mutex    sync.Mutex
+	pending  *atPending
+	handlers []atURCHandler
+}
+
+// atPending holds the lines of the command waiting for its final result code
+type atPending struct {
+	prefix string
+	lines  chan string
+}
+
+// NewAtCommandHandler creates a command handler reading from the port until it's closed, a command fails
+// when no line is received within the timeout
+func NewAtCommandHandler(port io.ReadWriter, timeout time.Duration, logger *Logger) *AtCommandHandler {
+
+	atCommandHandler := &AtCommandHandler{logger: logger,
+		writer:  port,
+		timeout: timeout,
+		dirty:   true,
+		closed:  make(chan struct{})}
+
+	go atCommandHandler.read(port)
+
+	return atCommandHandler
+}
+
+func (atCommandHandler *AtCommandHandler) read(port io.Reader) {
+
+	defer close(atCommandHandler.closed)
+
+	reader := bufio.NewReader(port)
+
+	for {
+
+		str, err := reader.ReadString(atSeperator)
+
+		if line := strings.TrimSpace(str); line != "" {
+			atCommandHandler.dispatch(line)
+		}
+
+		if err != nil {
+			atCommandHandler.err = err
+			return
+		}
+	}
+}
+
+// Closed is closed when the port cannot be read anymore
+func (atCommandHandler *AtCommandHandler) Closed() <-chan struct{} {
+	return atCommandHandler.closed
+}
+
+// begin makes the command the pending command, the lines not dispatched as unsolicited result code are passed to it
+func (atCommandHandler *AtCommandHandler) begin(command string) chan string {
+
+	pending := &atPending{prefix: atResponsePrefix(command), lines: make(chan string, 64)}
+
+	atCommandHandler.mutex.Lock()
+	atCommandHandler.pending = pending
+	atCommandHandler.mutex.Unlock()
+
+	return pending.lines
+}
+
+func (atCommandHandler *AtCommandHandler) end() {
+
+	atCommandHandler.mutex.Lock()
+	atCommandHandler.pending = nil
+	atCommandHandler.mutex.Unlock()
+}
+
+// readLine waits for the next line of the pending command
+func (atCommandHandler *AtCommandHandler) readLine(ctx context.Context, lines chan string) (string, error) {
+
+	timer := time.NewTimer(atCommandHandler.timeout)
+	defer timer.Stop()
+
+	select {
+	case line := <-lines:
+		return line, nil
+	case <-ctx.Done():
+		return "", ErrCommandCancelled
+	case <-timer.C:
+		return "", errNoData
+	case <-atCommandHandler.closed:
+		return "", atCommandHandler.err
+	}
+}
+
+// drain discards the lines received until no line is received within the timeout
+func (atCommandHandler *AtCommandHandler) drain() {
+
+	lines := atCommandHandler.begin("")
+	defer atCommandHandler.end()
+
+	for {
+
+		line, err := atCommandHandler.readLine(context.Background(), lines)
+
+		if err != nil {
+			break
+		}
+
+		if IsDebugMode() {
+			atCommandHandler.logger.DebugF("Drained following garbage: %v from serial port", line)
+		}
+	}
+
+	atCommandHandler.dirty = false
+}
+
+// Transaction sends the command and collects the intermediate lines up to the final result code,
+// an error is only returned when the command did not complete
+func (atCommandHandler *AtCommandHandler) Transaction(parentCtx context.Context, command string) (*ATResponse, error) {
+
+	// The late response of an earlier command must not be mistaken for the response of this one
+	if atCommandHandler.dirty {
+		atCommandHandler.drain()
+	}
+
+	ctx, cancel := ATCreateCommandContext(parentCtx)
+	defer cancel()
+
+	lines := atCommandHandler.begin(command)
+	defer atCommandHandler.end()
+
+	// Until the final result code is read the command is incomplete
+	atCommandHandler.dirty = true
+
+	cmdBytes := []byte(command + "\r")
+	n, err := atCommandHandler.writer.Write(cmdBytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n != len(cmdBytes) {
+		return nil, errors.New("invallid data length")
+	}
+
+	response := &ATResponse{Command: command}
+
+	for {
+
+		line, err := atCommandHandler.readLine(ctx, lines)
+
+		if err == ErrCommandCancelled && IsDebugMode() {
+			atCommandHandler.logger.Debugf("timing failure in command: %v", command)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip the echo of the command
+		if line == command {
+			continue
+		}
+
+		if result, ok := parseATFinalResult(line); ok {
+			response.Result = result
+			response.Final = line
+			atCommandHandler.dirty = false
+			return response, nil
+		}
+
+		response.Lines = append(response.Lines, line)
+	}
+}
+
+// Command runs a transaction, a command terminated with an error result code returns an ATError
+func (atCommandHandler *AtCommandHandler) Command(ctx context.Context, command string) (*ATResponse, error) {
+
+	response, err := atCommandHandler.Transaction(ctx, command)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response, response.Err()
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeModem echoes every command and writes the response of the command, a command without response is not answered
+func fakeModem(conn net.Conn, responses map[string]string, delays map[string]time.Duration) {
+
+	reader := bufio.NewReader(conn)
+
+	for {
+
+		command, err := reader.ReadString('\r')
+
+		if err != nil {
+			return
+		}
+
+		command = strings.TrimSpace(command)
+		response, ok := responses[command]
+
+		if !ok {
+			continue
+		}
+
+		time.Sleep(delays[command])
+
+		if _, err := conn.Write([]byte(command + "\r" + response)); err != nil {
+			return
+		}
+	}
+}
+
+func TestParseATFinalResult(t *testing.T) {
+
+	tests := []struct {
+		line     string
+		expected ATFinalResult
+		final    bool
+	}{
+		{"OK", ATResultOk, true},
+		{"ERROR", ATResultError, true},
+		{"+CME ERROR: SIM not inserted", ATResultCMEError, true},
+		{"+CMS ERROR: 500", ATResultCMSError, true},
+		{"NO CARRIER", ATResultNoCarrier, true},
+		{"BUSY", ATResultBusy, true},
+		{"NO ANSWER", ATResultNoAnswer, true},
+		{"CONNECT", ATResultConnect, true},
+		{"CONNECT 150000000", ATResultConnect, true},
+		{"+CSQ: 18,99", "", false},
+		{"OKAY", "", false},
+		{"CONNECTED", "", false},
+		{"+CME ERRORS", "", false},
+	}
+
+	for _, tt := range tests {
+		if result, final := parseATFinalResult(tt.line); result != tt.expected || final != tt.final {
+			t.Errorf("Line: %q expected: %q final: %v got: %q final: %v", tt.line, tt.expected, tt.final, result, final)
+		}
+	}
+}
+
+func TestATResponseErr(t *testing.T) {
+
+	tests := []struct {
+		final    string
+		expected *ATError
+	}{
+		{"OK", nil},
+		{"CONNECT 9600", nil},
+		{"+CME ERROR: SIM not inserted", &ATError{Command: "AT+CPIN?", Result: ATResultCMEError, Text: "SIM not inserted"}},
+		{"+CMS ERROR: 500", &ATError{Command: "AT+CPIN?", Result: ATResultCMSError, Text: "500"}},
+		{"NO CARRIER", &ATError{Command: "AT+CPIN?", Result: ATResultNoCarrier}},
+	}
+
+	for _, tt := range tests {
+
+		result, _ := parseATFinalResult(tt.final)
+		err := (&ATResponse{Command: "AT+CPIN?", Result: result, Final: tt.final}).Err()
+
+		if tt.expected == nil {
+			if err != nil {
+				t.Errorf("Final: %q expected no error got: %v", tt.final, err)
+			}
+			continue
+		}
+
+		if atError, ok := err.(*ATError); !ok || *atError != *tt.expected {
+			t.Errorf("Final: %q expected: %+v got: %#v", tt.final, tt.expected, err)
+		}
+	}
+}
+
+func TestAtCommandHandlerTransaction(t *testing.T) {
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	port, modem := net.Pipe()
+	defer port.Close()
+
+	go fakeModem(modem, map[string]string{
+		"AT":         "\r\nOK\r\n",
+		"AT+CSQ":     "\r\n+CSQ: 18,99\r\n\r\nOK\r\n",
+		"AT+CCID":    "\r\n+CCID: \"8931087616027213997F\"\r\n\r\nOK\r\n",
+		"AT+CPIN?":   "\r\n+CME ERROR: SIM not inserted\r\n",
+		"AT+CNSMOD?": "\r\n+CNSMOD: 0,x\r\n\r\nOK\r\n",
+		"AT+COPS=?":  "\r\n+COPS: (2,\"KPN\",\"KPN\",\"20408\",7)\r\n+COPS: (1,\"Vodafone\",\"VF\",\"20404\",2)\r\n\r\nOK\r\n",
+		"ATD*99#":    "\r\nCONNECT 150000000\r\n",
+		"AT+SLOW":    "\r\nOK\r\n",
+	}, map[string]time.Duration{"AT+SLOW": 300 * time.Millisecond})
+
+	handler := NewAtCommandHandler(port, 200*time.Millisecond, logger)
+	ctx := context.Background()
+
+	if err := AT(ctx, handler); err != nil {
+		t.Errorf("Got unexpected error for AT: %v", err)
+	}
+
+	if csq, err := ATCSQ(ctx, handler); err != nil || csq != (CsqResult{Csq: 18, Ber: 99}) {
+		t.Errorf("Expected csq: 18,99 got: %+v %v", csq, err)
+	}
+
+	if ccid, err := ATCCID(ctx, handler); err != nil || ccid != "8931087616027213997F" {
+		t.Errorf("Expected ccid: 8931087616027213997F got: %q %v", ccid, err)
+	}
+
+	// The modem error is recoverable
+	if err := ATCPIN(ctx, handler); TryHandleAtCommandError(logger, "AT+CPIN?", err, func() {}) != nil {
+		t.Errorf("Expected a recoverable error for AT+CPIN? got: %#v", err)
+	}
+
+	if accessTechnology, err := ATCNSMOD(ctx, handler); !isATRecoverable(err) || accessTechnology.ConnType != ConnTypeNoNetwork {
+		t.Errorf("Expected a recoverable error for a malformed line got: %+v %v", accessTechnology, err)
+	}
+
+	// A response without the information line is recoverable
+	if response, err := handler.Command(ctx, "AT"); err != nil {
+		t.Errorf("Got unexpected error for AT: %v", err)
+	} else if _, err := response.First("+CSQ:"); !isATRecoverable(err) {
+		t.Errorf("Expected a recoverable error for a missing line got: %#v", err)
+	}
+
+	response, err := handler.Command(ctx, "AT+COPS=?")
+
+	if err != nil || len(response.Prefixed("+COPS:")) != 2 {
+		t.Errorf("Expected two operators got: %+v %v", response, err)
+	}
+
+	if response, err := handler.Command(ctx, "ATD*99#"); err != nil || response.Result != ATResultConnect {
+		t.Errorf("Expected connect got: %+v %v", response, err)
+	}
+
+	// An unanswered command is not recoverable
+	if _, err := handler.Command(ctx, "AT+UNKNOWN"); TryHandleAtCommandError(logger, "AT+UNKNOWN", err, func() {}) == nil {
+		t.Errorf("Expected a timeout for an unanswered command")
+	}
+
+	if _, err := handler.Command(ctx, "AT+SLOW"); err == nil {
+		t.Errorf("Expected a timeout for the slow command")
+	}
+
+	// The late OK of the slow command is drained instead of completing the next command
+	lines, err := ATRaw(ctx, handler, "AT+CSQ")
+
+	if expected := []string{"+CSQ: 18,99", "OK"}; err != nil || !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected: %q got: %q %v", expected, lines, err)
+	}
+}
+
+func TestATResponsePrefix(t *testing.T) {
+
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"AT+CREG?", "+CREG:"},
+		{"AT+CSQ", "+CSQ:"},
+		{"AT+CMEE=2", "+CMEE:"},
+		{"AT+COPS=?", "+COPS:"},
+		{"at+cereg?", "+CEREG:"},
+		{"ATE0", ""},
+		{"AT", ""},
+	}
+
+	for _, tt := range tests {
+		if prefix := atResponsePrefix(tt.command); prefix != tt.expected {
+			t.Errorf("Command: %q expected: %q got: %q", tt.command, tt.expected, prefix)
+		}
+	}
+}
+
+func TestAtCommandHandlerUnsolicited(t *testing.T) {
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	port, modem := net.Pipe()
+	defer port.Close()
+
+	// The unsolicited result codes are interleaved with the responses
+	go fakeModem(modem, map[string]string{
+		"AT":       "\r\nOK\r\n",
+		"AT+CSQ":   "\r\n+CEREG: 5\r\n+CSQ: 18,99\r\n\r\nRING\r\n\r\nOK\r\n",
+		"AT+CREG?": "\r\n+CREG: 0,1\r\n\r\nOK\r\n",
+	}, nil)
+
+	handler := NewAtCommandHandler(port, 200*time.Millisecond, logger)
+	received := make(chan string, 10)
+
+	for _, prefix := range []string{"+CREG:", "+CEREG:", "+CPIN:"} {
+		handler.HandleURC(prefix, func(line string) {
+			received <- line
+		})
+	}
+
+	ctx := context.Background()
+
+	if err := AT(ctx, handler); err != nil {
+		t.Fatalf("Got unexpected error for AT: %v", err)
+	}
+
+	response, err := handler.Command(ctx, "AT+CSQ")
+
+	if expected := []string{"+CSQ: 18,99"}; err != nil || !reflect.DeepEqual(response.Lines, expected) {
+		t.Errorf("Expected: %q got: %+v %v", expected, response, err)
+	}
+
+	// The information line of the command is not dispatched
+	response, err = handler.Command(ctx, "AT+CREG?")
+
+	if expected := []string{"+CREG: 0,1"}; err != nil || !reflect.DeepEqual(response.Lines, expected) {
+		t.Errorf("Expected: %q got: %+v %v", expected, response, err)
+	}
+
+	// An unsolicited result code between commands
+	if _, err := modem.Write([]byte("\r\n+CPIN: NOT READY\r\n\r\n+CREG: 2\r\n")); err != nil {
+		t.Fatalf("Cannot write: %v", err)
+	}
+
+	for _, expected := range []string{"+CEREG: 5", "+CPIN: NOT READY", "+CREG: 2"} {
+		select {
+		case line := <-received:
+			if line != expected {
+				t.Errorf("Expected: %q got: %q", expected, line)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected: %q to be dispatched", expected)
+		}
+	}
+
+	// A closed port fails the pending and next commands
+	modem.Close()
+
+	if _, err := handler.Command(ctx, "AT"); err == nil {
+		t.Errorf("Expected an error after closing the port")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import "strings"
+
+// atUnsolicitedPrefixes are the unsolicited result codes the modem emits on its own, they are never part of
+// the response of another command
+var atUnsolicitedPrefixes = []string{
+	"+CREG:",
+	"+CGREG:",
+	"+CEREG:",
+	"+CPIN:",
+	"+CMTI:",
+	"+CRING:",
+	"RING",
+	"SMS DONE",
+	"PB DONE",
+}
+
+// atURCHandler calls the function for every unsolicited result code with the prefix
+type atURCHandler struct {
+	prefix string
+	handle func(line string)
+}
+
+// HandleURC registers the function for the unsolicited result codes with the prefix, the function is called
+// from the reader goroutine so it must not block or send commands
+func (atCommandHandler *AtCommandHandler) HandleURC(prefix string, handle func(line string)) {
+
+	atCommandHandler.mutex.Lock()
+	defer atCommandHandler.mutex.Unlock()
+
+	atCommandHandler.handlers = append(atCommandHandler.handlers, atURCHandler{prefix: prefix, handle: handle})
+}
+
+// dispatch passes the line to the handlers of an unsolicited result code or to the pending command
+func (atCommandHandler *AtCommandHandler) dispatch(line string) {
+
+	atCommandHandler.mutex.Lock()
+
+	pending := atCommandHandler.pending
+	var handlers []atURCHandler
+
+	for _, handler := range atCommandHandler.handlers {
+		if strings.HasPrefix(line, handler.prefix) {
+			handlers = append(handlers, handler)
+		}
+	}
+
+	atCommandHandler.mutex.Unlock()
+
+	// The information line of a command can look like an unsolicited result code: AT+CREG? responds with +CREG: 0,1
+	solicited := pending != nil && pending.prefix != "" && strings.HasPrefix(line, pending.prefix)
+
+	if !solicited && (len(handlers) > 0 || isATUnsolicited(line)) {
+
+		if len(handlers) == 0 && IsDebugMode() {
+			atCommandHandler.logger.Debugf("Unhandled unsolicited result code: %v", line)
+		}
+
+		for _, handler := range handlers {
+			handler.handle(line)
+		}
+
+		return
+	}
+
+	if pending == nil {
+		if IsDebugMode() {
+			atCommandHandler.logger.DebugF("Drained following garbage: %v from serial port", line)
+		}
+		return
+	}
+
+	select {
+	case pending.lines <- line:
+	default:
+		atCommandHandler.logger.Warningf("Discarded line: %v the response of the command is too long", line)
+	}
+}
+
+func isATUnsolicited(line string) bool {
+
+	for _, prefix := range atUnsolicitedPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// atResponsePrefix returns the prefix of the information lines of an extended command: +CREG: for AT+CREG?
+func atResponsePrefix(command string) string {
+
+	upper := strings.ToUpper(command)
+
+	if !strings.HasPrefix(upper, "AT+") {
+		return ""
+	}
+
+	name := upper[2:]
+
+	if i := strings.IndexAny(name, "=?"); i >= 0 {
+		name = name[:i]
+	}
+
+	return name + ":"
+}
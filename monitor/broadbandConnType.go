@@ -1,5 +1,10 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+)
+
 // BroadbandConnType connection type
 type BroadbandConnType int
 
@@ -10,6 +15,50 @@ const (
 	ConnType2G BroadbandConnType = 1
 	// ConnType3G means HSDPA or HSUPA, etc
 	ConnType3G BroadbandConnType = 2
-	// ConnType4G means lte or other
+	// ConnType4G means lte, including Cat-M and NB-IoT
 	ConnType4G BroadbandConnType = 3
+	// ConnType5G means nr
+	ConnType5G BroadbandConnType = 4
 )
+
+// AccessTechnology structure, the radio access technology the modem uses and its generation
+type AccessTechnology struct {
+	Name     string
+	ConnType BroadbandConnType
+}
+
+func (broadbandConnType BroadbandConnType) String() string {
+
+	switch broadbandConnType {
+	case ConnTypeNoNetwork:
+		return "none"
+	case ConnType2G:
+		return "2G"
+	case ConnType3G:
+		return "3G"
+	case ConnType4G:
+		return "4G"
+	case ConnType5G:
+		return "5G"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(broadbandConnType))
+	}
+}
+
+// MarshalText encodes the connection type by name
+func (broadbandConnType BroadbandConnType) MarshalText() ([]byte, error) {
+	return []byte(broadbandConnType.String()), nil
+}
+
+// UnmarshalText decodes the connection type by name
+func (broadbandConnType *BroadbandConnType) UnmarshalText(text []byte) error {
+
+	for _, connType := range []BroadbandConnType{ConnTypeNoNetwork, ConnType2G, ConnType3G, ConnType4G, ConnType5G} {
+		if strings.EqualFold(connType.String(), string(text)) {
+			*broadbandConnType = connType
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown connection type: %s", text)
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Command structure describing a single command line command
+type Command struct {
+	Name    string
+	Usage   string
+	Summary string
+	Daemon  bool
+	Run     func(logger *Logger, out io.Writer, args []string) error
+}
+
+var errUsage = errors.New("invalid usage")
+
+func commandLineCommands() []*Command {
+
+	return []*Command{
+		{Name: "run", Usage: "run [-config path]", Summary: "run the monitor daemon (default)", Daemon: true, Run: runCommand},
+		{Name: "status", Usage: "status [-config path]", Summary: "print the decoded status message last sent by the daemon", Run: statusCommand},
+		{Name: "listen", Usage: "listen [-config path] [-ack] [address]", Summary: "print the status datagrams received on an address (default status.address)", Run: listenCommand},
+		{Name: "decode", Usage: "decode <hex>", Summary: "decode a status frame or datagram copied from a log", Run: decodeCommand},
+		{Name: "at", Usage: "at [-config path] [-timeout duration] \"<command>\"", Summary: "send a single AT command to the modem and print the reply", Run: atCommand},
+		{Name: "led", Usage: "led [-config path] <name> <state>", Summary: "set a led (eth0, eth1, wifi, broadband, rimote)", Run: ledCommand},
+		{Name: "hostinfo", Usage: "hostinfo [-config path] [-modem=false]", Summary: "print the rimote host info file content", Run: hostInfoCommand},
+	}
+}
+
+// RunCommandLine runs the command selected by the arguments and returns the exit code
+func RunCommandLine(args []string) int {
+
+	commands := commandLineCommands()
+
+	// Without arguments we run the daemon like we always did.
+	name := "run"
+
+	if len(args) > 0 {
+		name = args[0]
+		args = args[1:]
+	}
+
+	if name == "help" || name == "-h" || name == "--help" {
+		writeUsage(os.Stdout, commands)
+		return 0
+	}
+
+	for _, command := range commands {
+
+		if command.Name != name {
+			continue
+		}
+
+		// Keep our output clean for non daemon commands.
+		var logOutput io.Writer = os.Stderr
+		if command.Daemon {
+			logOutput = os.Stdout
+		}
+
+		logger, err := New("test", 1, logOutput)
+
+		if err != nil {
+			panic(err)
+		}
+
+		err = command.Run(logger, os.Stdout, args)
+
+		if err == errUsage || err == flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "usage: monitor %v\n", command.Usage)
+			return 2
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monitor %v: %v\n", command.Name, err)
+			return 1
+		}
+
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command: %v\n", name)
+	writeUsage(os.Stderr, commands)
+	return 2
+}
+
+func writeUsage(w io.Writer, commands []*Command) {
+
+	fmt.Fprintln(w, "usage: monitor <command> [arguments]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "commands:")
+
+	for _, command := range commands {
+		fmt.Fprintf(w, "  %-10v %v\n", command.Name, command.Summary)
+	}
+}
+
+func newCommandFlagSet(name string) (*flag.FlagSet, *string) {
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	configPath := flags.String("config", ConfigurationFilePath(), "configuration file")
+
+	return flags, configPath
+}
+
+func loadCommandConfiguration(logger *Logger, path string) (*Configuration, error) {
+
+	config, err := LoadConfiguration(path)
+
+	if os.IsNotExist(err) {
+		if IsDebugMode() {
+			logger.DebugF("No configuration file found at: %v using defaults", path)
+		}
+		return config, nil
+	}
+
+	return config, err
+}
+
+func runCommand(logger *Logger, out io.Writer, args []string) error {
+
+	flags, configPath := newCommandFlagSet("run")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(*configPath); os.IsNotExist(err) {
+		logger.InfoF("No configuration file found at: %v using defaults", *configPath)
+	}
+
+	config, err := loadCommandConfiguration(logger, *configPath)
+
+	if err != nil {
+		return err
+	}
+
+	runDaemon(logger, NewConfigurationStore(*configPath, config))
+	return nil
+}
+
+func statusCommand(logger *Logger, out io.Writer, args []string) error {
+
+	flags, configPath := newCommandFlagSet("status")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadCommandConfiguration(logger, *configPath)
+
+	if err != nil {
+		return err
+	}
+
+	if config.Status.StateFile == "" {
+		return errors.New("status.state_file is disabled in the configuration")
+	}
+
+	msg, written, err := ReadStatusFile(config.Status.StateFile)
+
+	if err != nil {
+		return fmt.Errorf("cannot read the status, is the daemon running? (%v)", err)
+	}
+
+	fmt.Fprintf(out, "updated: %v (%v ago)\n", written.Format(defTimeFmt), time.Since(written).Truncate(time.Second))
+	WriteDecodedMessage(out, msg)
+
+	return nil
+}
+
+func listenCommand(logger *Logger, out io.Writer, args []string) error {
+
+	flags, configPath := newCommandFlagSet("listen")
+	ack := flags.Bool("ack", false, "acknowledge every datagram like a receiver with feedback")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 1 {
+		return errUsage
+	}
+
+	address := flags.Arg(0)
+
+	if address == "" {
+		config, err := loadCommandConfiguration(logger, *configPath)
+
+		if err != nil {
+			return err
+		}
+
+		address = config.Status.Address
+	}
+
+	if address == "" {
+		return errors.New("no address given and status.address is disabled in the configuration")
+	}
+
+	conn, err := listenStatusDatagrams(address)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	fmt.Fprintf(out, "listening @ %v\n", conn.LocalAddr())
+
+	return printStatusDatagrams(conn, out, *ack)
+}
+
+// listenStatusDatagrams binds the address, a multicast address joins the group
+func listenStatusDatagrams(address string) (*net.UDPConn, error) {
+
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if udpAddress.IP.IsMulticast() {
+		return net.ListenMulticastUDP("udp", nil, udpAddress)
+	}
+
+	return net.ListenUDP("udp", udpAddress)
+}
+
+// printStatusDatagrams prints every received datagram until reading fails, changes to the previous message are marked
+func printStatusDatagrams(conn net.PacketConn, out io.Writer, ack bool) error {
+
+	started := time.Now()
+	var previous *Message
+	buffer := make([]byte, 1500)
+
+	for {
+		n, from, err := conn.ReadFrom(buffer)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "\n%v from: %v\n", time.Now().Format(defTimeFmt), from)
+
+		datagram, err := DecodeStatusDatagram(buffer[:n])
+
+		if err != nil {
+			fmt.Fprintf(out, "invalid datagram: %v (% x)\n", err, buffer[:n])
+			continue
+		}
+
+		writeStatusDatagramHeader(out, datagram)
+		WriteDecodedChanges(out, previous, &datagram.Message)
+		previous = &datagram.Message
+
+		if ack {
+			if _, err := conn.WriteTo(EncodeStatusAck(datagram.Sequence, time.Since(started)), from); err != nil {
+				fmt.Fprintf(out, "cannot acknowledge: %v\n", err)
+			}
+		}
+	}
+}
+
+func decodeCommand(logger *Logger, out io.Writer, args []string) error {
+
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+
+	// Allow the hex bytes to be passed as separate arguments
+	data, err := ParseStatusHex(strings.Join(flags.Args(), " "))
+
+	if err != nil {
+		return err
+	}
+
+	datagram, err := DecodeStatusDatagram(data)
+
+	if err != nil {
+		return err
+	}
+
+	writeStatusDatagramHeader(out, datagram)
+	WriteDecodedMessage(out, &datagram.Message)
+
+	return nil
+}
+
+func writeStatusDatagramHeader(w io.Writer, datagram *StatusDatagram) {
+
+	if datagram.Version == 1 {
+		fmt.Fprintln(w, "format: legacy")
+		return
+	}
+
+	fmt.Fprintf(w, "format: v%v sequence: %v uptime: %v interval: %v\n", datagram.Version, datagram.Sequence, datagram.Timestamp, datagram.Interval)
+}
+
+func atCommand(logger *Logger, out io.Writer, args []string) error {
+
+	flags, configPath := newCommandFlagSet("at")
+	timeout := flags.Duration("timeout", 0, "read timeout (defaults to modem.command_timeout)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+
+	config, err := loadCommandConfiguration(logger, *configPath)
+
+	if err != nil {
+		return err
+	}
+
+	if *timeout <= 0 {
+		*timeout = config.Modem.CommandTimeout
+	}
+
+	port, handler, err := openModemCommandHandler(&config.Modem, *timeout, logger)
+
+	if err != nil {
+		return err
+	}
+
+	defer port.Close()
+
+	lines, err := ATRaw(context.Background(), handler, flags.Arg(0))
+
+	for _, line := range lines {
+		fmt.Fprintln(out, line)
+	}
+
+	return err
+}
+
+func ledCommand(logger *Logger, out io.Writer, args []string) error {
+
+	flags, configPath := newCommandFlagSet("led")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+
+	config, err := loadCommandConfiguration(logger, *configPath)
+
+	if err != nil {
+		return err
+	}
+
+	ConfigureLeds(config.Leds)
+
+	name := strings.ToLower(flags.Arg(0))
+	state := strings.ToLower(flags.Arg(1))
+
+	switch name {
+	case "eth0", "wan", "eth1", "lan":
+		setLed := SetEth0Led
+		if name == "eth1" || name == "lan" {
+			setLed = SetEth1Led
+		}
+
+		switch state {
+		case "unconfigured":
+			return setLed(false, false)
+		case "disconnected":
+			return setLed(true, false)
+		case "connected":
+			return setLed(true, true)
+		default:
+			return fmt.Errorf("unknown ethernet led state: %v (unconfigured, disconnected, connected)", state)
+		}
+	case "wifi", "broadband":
+		strength, err := ParseSignalStrength(state)
+
+		if err != nil {
+			return fmt.Errorf("%v (error, none, weak, fair, good)", err)
+		}
+
+		if name == "wifi" {
+			return SetWifiLed(strength)
+		}
+
+		return SetBroadbandLed(strength)
+	case "rimote", "power":
+		switch state {
+		case "connected":
+			return SetRimoteLed(true)
+		case "disconnected":
+			return SetRimoteLed(false)
+		default:
+			return fmt.Errorf("unknown rimote led state: %v (connected, disconnected)", state)
+		}
+	default:
+		return fmt.Errorf("unknown led: %v", name)
+	}
+}
+
+func hostInfoCommand(logger *Logger, out io.Writer, args []string) error {
+
+	flags, configPath := newCommandFlagSet("hostinfo")
+	queryModem := flags.Bool("modem", true, "query the modem for the sim-id")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadCommandConfiguration(logger, *configPath)
+
+	if err != nil {
+		return err
+	}
+
+	firmwareVersion, err := GetFirmwareVersion(config.HostInfo.FirmwareFile)
+
+	if err != nil {
+		logger.WarningF("Cannot detect firmware version: %v", err)
+	}
+
+	hostInfo := &HostInfo{FirmwareVersion: firmwareVersion}
+	modemInfo := HostInfo{ModemEnabled: CheckModemAvailable(&config.Modem)}
+
+	if *queryModem && modemInfo.ModemEnabled {
+		modemInfo.SimID, err = querySimID(&config.Modem, logger)
+
+		if err != nil {
+			logger.WarningF("Cannot read sim-id from modem: %v", err)
+		}
+	}
+
+	hostInfo.UpdateModemInfo(modemInfo, DeviceIsUsingFactoryConfig(&config.HostInfo))
+
+	_, err = out.Write(FormatRimoteInfo(hostInfo))
+	return err
+}
+
+func querySimID(config *ModemConfiguration, logger *Logger) (string, error) {
+
+	port, handler, err := openModemCommandHandler(config, config.CommandTimeout, logger)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer port.Close()
+
+	return ATCCID(context.Background(), handler)
+}
+
+func openModemCommandHandler(config *ModemConfiguration, timeout time.Duration, logger *Logger) (*Port, *AtCommandHandler, error) {
+
+	port, err := OpenPort(&Config{
+		Name: config.PortName(),
+		Baud: config.Baud,
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return port, NewAtCommandHandler(port, timeout, logger), nil
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultConfigurationFilePath the path of the configuration file when not overridden by the environment
+const DefaultConfigurationFilePath string = "/etc/rm-monitor/monitor.yaml"
+
+// Configuration structure
+type Configuration struct {
+	Modem      ModemConfiguration       `yaml:"modem"`
+	Status     StatusConfiguration      `yaml:"status"`
+	Rimote     RimoteConfiguration      `yaml:"rimote"`
+	Ethernet   EthernetConfiguration    `yaml:"ethernet"`
+	Leds       LedConfiguration         `yaml:"leds"`
+	HostInfo   HostInfoConfiguration    `yaml:"hostinfo"`
+	Log        LogConfiguration         `yaml:"log"`
+	API        APIConfiguration         `yaml:"api"`
+	Watchdog   WatchdogConfiguration    `yaml:"watchdog"`
+	Supervisor SupervisorConfiguration  `yaml:"supervisor"`
+	Events     EventSocketConfiguration `yaml:"events"`
+	MQTT       MQTTConfiguration        `yaml:"mqtt"`
+	Vcc        VccConfiguration         `yaml:"vcc"`
+	Storage    StorageConfiguration     `yaml:"storage"`
+	Services   ServicesConfiguration    `yaml:"services"`
+	Monitors   map[string]bool          `yaml:"monitors"`
+}
+
+// ModemConfiguration structure
+type ModemConfiguration struct {
+	Port              string        `yaml:"port"`
+	DebugPort         string        `yaml:"debug_port"`
+	Baud              int           `yaml:"baud"`
+	ConfigFile        string        `yaml:"config_file"`
+	CommandTimeout    time.Duration `yaml:"command_timeout"`
+	PollInterval      time.Duration `yaml:"poll_interval"`
+	RetryInterval     time.Duration `yaml:"retry_interval"`
+	PreFlightAttempts int           `yaml:"preflight_attempts"`
+	PreFlightInterval time.Duration `yaml:"preflight_interval"`
+}
+
+// StatusConfiguration structure, feedback applies to the address only
+type StatusConfiguration struct {
+	Address         string         `yaml:"address"`
+	Interval        time.Duration  `yaml:"interval"`
+	StateFile       string         `yaml:"state_file"`
+	Formats         []string       `yaml:"formats"`
+	Feedback        bool           `yaml:"feedback"`
+	FeedbackTimeout time.Duration  `yaml:"feedback_timeout"`
+	Targets         []StatusTarget `yaml:"targets"`
+}
+
+// StatusTarget structure, a target without formats uses the formats of the status configuration
+type StatusTarget struct {
+	Address   string   `yaml:"address"`
+	TTL       int      `yaml:"ttl"`
+	Interface string   `yaml:"interface"`
+	Formats   []string `yaml:"formats"`
+	Feedback  bool     `yaml:"feedback"`
+}
+
+// RimoteConfiguration structure
+type RimoteConfiguration struct {
+	Endpoint string        `yaml:"endpoint"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// EthernetConfiguration structure
+type EthernetConfiguration struct {
+	Eth0     string        `yaml:"eth0"`
+	Eth1     string        `yaml:"eth1"`
+	Wifi     string        `yaml:"wifi"`
+	Ppp      string        `yaml:"ppp"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// LedConfiguration structure
+type LedConfiguration struct {
+	PowerRed       ManagerGpio `yaml:"power_red"`
+	PowerGreen     ManagerGpio `yaml:"power_green"`
+	PowerBlue      ManagerGpio `yaml:"power_blue"`
+	WanRed         ManagerGpio `yaml:"wan_red"`
+	LanRed         ManagerGpio `yaml:"lan_red"`
+	WifiRed        ManagerGpio `yaml:"wifi_red"`
+	WifiGreen      ManagerGpio `yaml:"wifi_green"`
+	WifiBlue       ManagerGpio `yaml:"wifi_blue"`
+	BroadbandRed   ManagerGpio `yaml:"broadband_red"`
+	BroadbandGreen ManagerGpio `yaml:"broadband_green"`
+	BroadbandBlue  ManagerGpio `yaml:"broadband_blue"`
+}
+
+// HostInfoConfiguration structure
+type HostInfoConfiguration struct {
+	FirmwareFile      string        `yaml:"firmware_file"`
+	RimoteInfoFile    string        `yaml:"rimote_info_file"`
+	SystemConfigFile  string        `yaml:"system_config_file"`
+	FactoryConfigFile string        `yaml:"factory_config_file"`
+	WaitTimeout       time.Duration `yaml:"wait_timeout"`
+}
+
+// LogConfiguration structure
+type LogConfiguration struct {
+	Level string `yaml:"level"`
+}
+
+// APIConfiguration structure
+type APIConfiguration struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+}
+
+// EventSocketConfiguration structure
+type EventSocketConfiguration struct {
+	Enabled bool        `yaml:"enabled"`
+	Path    string      `yaml:"path"`
+	Mode    os.FileMode `yaml:"mode"`
+	Group   string      `yaml:"group"`
+	Buffer  int         `yaml:"buffer"`
+}
+
+// MQTTConfiguration structure, {client_id} in a topic is replaced by the client id
+type MQTTConfiguration struct {
+	Enabled           bool                 `yaml:"enabled"`
+	Address           string               `yaml:"address"`
+	ClientID          string               `yaml:"client_id"`
+	Username          string               `yaml:"username"`
+	Password          string               `yaml:"password"`
+	QoS               int                  `yaml:"qos"`
+	KeepAlive         time.Duration        `yaml:"keep_alive"`
+	Timeout           time.Duration        `yaml:"timeout"`
+	ReconnectInterval time.Duration        `yaml:"reconnect_interval"`
+	Buffer            int                  `yaml:"buffer"`
+	Topics            MQTTTopics           `yaml:"topics"`
+	TLS               MQTTTLSConfiguration `yaml:"tls"`
+}
+
+// MQTTTopics structure, an empty topic is not published
+type MQTTTopics struct {
+	Availability string `yaml:"availability"`
+	State        string `yaml:"state"`
+	Events       string `yaml:"events"`
+	Modem        string `yaml:"modem"`
+	HostInfo     string `yaml:"hostinfo"`
+}
+
+// MQTTTLSConfiguration structure, without a ca file the system roots are used
+type MQTTTLSConfiguration struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// VccConfiguration structure
+type VccConfiguration struct {
+	Interval time.Duration `yaml:"interval"`
+	Channels []VccChannel  `yaml:"channels"`
+}
+
+// VccChannel structure, the voltage is read from the input of a hwmon or iio device in volts
+type VccChannel struct {
+	Name       string  `yaml:"name"`
+	Hwmon      string  `yaml:"hwmon"`
+	IIO        string  `yaml:"iio"`
+	Input      string  `yaml:"input"`
+	Scale      float64 `yaml:"scale"`
+	Min        float64 `yaml:"min"`
+	Max        float64 `yaml:"max"`
+	Hysteresis float64 `yaml:"hysteresis"`
+}
+
+// StorageConfiguration structure, checks which don't apply to the storage of the device are skipped
+type StorageConfiguration struct {
+	Interval        time.Duration `yaml:"interval"`
+	MaxBadBlocks    int           `yaml:"max_bad_blocks"`
+	MaxECCFailures  int           `yaml:"max_ecc_failures"`
+	MinReservedPEBs int           `yaml:"min_reserved_pebs"`
+	MaxLifeTime     int           `yaml:"max_life_time"`
+	MaxPreEOL       int           `yaml:"max_pre_eol"`
+	WritableMounts  []string      `yaml:"writable_mounts"`
+	FreeSpacePath   string        `yaml:"free_space_path"`
+	MinFreePercent  float64       `yaml:"min_free_percent"`
+}
+
+// ServicesConfiguration structure, a service restarted more than max restarts within the window is crash looping
+type ServicesConfiguration struct {
+	Interval      time.Duration `yaml:"interval"`
+	Units         []string      `yaml:"units"`
+	Processes     []string      `yaml:"processes"`
+	RestartWindow time.Duration `yaml:"restart_window"`
+	MaxRestarts   int           `yaml:"max_restarts"`
+}
+
+// WatchdogConfiguration structure
+type WatchdogConfiguration struct {
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+}
+
+// SupervisorConfiguration structure
+type SupervisorConfiguration struct {
+	StaleTimeout      time.Duration `yaml:"stale_timeout"`
+	CheckInterval     time.Duration `yaml:"check_interval"`
+	RestartBackoff    time.Duration `yaml:"restart_backoff"`
+	MaxRestartBackoff time.Duration `yaml:"max_restart_backoff"`
+}
+
+// DefaultConfiguration returns the configuration matching the device defaults
+func DefaultConfiguration() *Configuration {
+
+	return &Configuration{
+		Modem: ModemConfiguration{
+			Port:              "/dev/ttyUSB3",
+			DebugPort:         "COM10",
+			Baud:              115200,
+			ConfigFile:        "/etc/wvdial.conf",
+			CommandTimeout:    5 * time.Second,
+			PollInterval:      10 * time.Second,
+			RetryInterval:     30 * time.Second,
+			PreFlightAttempts: 10,
+			PreFlightInterval: 5 * time.Second,
+		},
+		Status: StatusConfiguration{
+			Address:         "127.0.0.1:9876",
+			Interval:        2 * time.Second,
+			StateFile:       "/var/run/rm-monitor.status",
+			Formats:         []string{StatusFormatLegacy},
+			FeedbackTimeout: 10 * time.Second,
+		},
+		Rimote: RimoteConfiguration{
+			Endpoint: "http://localhost:9000/api/rimote/info",
+			Interval: 30 * time.Second,
+		},
+		Ethernet: EthernetConfiguration{
+			Eth0:     "eth0",
+			Eth1:     "eth1",
+			Wifi:     "wifi0",
+			Ppp:      "ppp0",
+			Interval: 15 * time.Second,
+		},
+		Leds: DefaultLedConfiguration(),
+		HostInfo: HostInfoConfiguration{
+			FirmwareFile:      DeviceFirmwareFilePath,
+			RimoteInfoFile:    DeviceRimoteInfoFilePath,
+			SystemConfigFile:  SystemConfigurationFilePath,
+			FactoryConfigFile: FactorySystemConfigurationFilePath,
+			WaitTimeout:       45 * time.Second,
+		},
+		Log: LogConfiguration{
+			Level: "info",
+		},
+		API: APIConfiguration{
+			Address: "127.0.0.1:9877",
+		},
+		Events: EventSocketConfiguration{
+			Path:   "/var/run/rm-monitor.sock",
+			Mode:   0660,
+			Buffer: 32,
+		},
+		MQTT: MQTTConfiguration{
+			Address:           "localhost:1883",
+			QoS:               1,
+			KeepAlive:         60 * time.Second,
+			Timeout:           10 * time.Second,
+			ReconnectInterval: 30 * time.Second,
+			Buffer:            64,
+			Topics: MQTTTopics{
+				Availability: "rm-monitor/{client_id}/availability",
+				State:        "rm-monitor/{client_id}/status",
+				Events:       "rm-monitor/{client_id}/events",
+				Modem:        "rm-monitor/{client_id}/modem",
+				HostInfo:     "rm-monitor/{client_id}/hostinfo",
+			},
+		},
+		Vcc: VccConfiguration{
+			Interval: 10 * time.Second,
+		},
+		Storage: StorageConfiguration{
+			Interval:        time.Minute,
+			MaxBadBlocks:    40,
+			MaxECCFailures:  0,
+			MinReservedPEBs: 1,
+			MaxLifeTime:     9,
+			MaxPreEOL:       2,
+			WritableMounts:  []string{"/data"},
+			FreeSpacePath:   "/data",
+			MinFreePercent:  5,
+		},
+		Services: ServicesConfiguration{
+			Interval:      15 * time.Second,
+			RestartWindow: 10 * time.Minute,
+			MaxRestarts:   3,
+		},
+		Watchdog: WatchdogConfiguration{
+			StallTimeout: 5 * time.Minute,
+		},
+		Supervisor: SupervisorConfiguration{
+			StaleTimeout:      3 * time.Minute,
+			CheckInterval:     15 * time.Second,
+			RestartBackoff:    time.Second,
+			MaxRestartBackoff: 5 * time.Minute,
+		},
+	}
+}
+
+// DefaultLedConfiguration returns the gpio mapping of the default hardware revision
+func DefaultLedConfiguration() LedConfiguration {
+
+	return LedConfiguration{
+		PowerRed:       LedPowerRed,
+		PowerGreen:     LedPowerGreen,
+		PowerBlue:      LedPowerBlue,
+		WanRed:         LedWanRed,
+		LanRed:         LedLanRed,
+		WifiRed:        LedWifiRed,
+		WifiGreen:      LedWifiGreen,
+		WifiBlue:       LedWifiBlue,
+		BroadbandRed:   LedBroadbandRed,
+		BroadbandGreen: LedBroadbandGreen,
+		BroadbandBlue:  LedBroadbandBlue,
+	}
+}
+
+// LoadConfiguration loads the configuration file, values missing from the file keep their default
+func LoadConfiguration(path string) (*Configuration, error) {
+
+	config := DefaultConfiguration()
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return config, err
+	}
+
+	config, err = ParseConfiguration(data)
+
+	if err != nil {
+		return DefaultConfiguration(), fmt.Errorf("invalid configuration file %v: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// ParseConfiguration parses yaml configuration data on top of the defaults
+func ParseConfiguration(data []byte) (*Configuration, error) {
+
+	config := DefaultConfiguration()
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate checks if the configuration is usable
+func (config *Configuration) Validate() error {
+
+	if config.Modem.Port == "" {
+		return errors.New("modem.port cannot be empty")
+	}
+
+	if config.Status.Address == "" && len(config.Status.Targets) == 0 {
+		return errors.New("status.address cannot be empty without status.targets")
+	}
+
+	if len(config.Status.Formats) == 0 {
+		return errors.New("status.formats cannot be empty")
+	}
+
+	if err := validateStatusFormats("status.formats", config.Status.Formats); err != nil {
+		return err
+	}
+
+	for i, target := range config.Status.Targets {
+
+		if target.Address == "" {
+			return fmt.Errorf("status.targets[%v].address cannot be empty", i)
+		}
+
+		// Acknowledgements are only received from the address we send to
+		if target.Feedback {
+			if addr, err := net.ResolveUDPAddr("udp", target.Address); err == nil && addr.IP.IsMulticast() {
+				return fmt.Errorf("status.targets[%v].feedback is not supported for multicast groups", i)
+			}
+		}
+
+		if target.TTL < 0 || target.TTL > 255 {
+			return fmt.Errorf("status.targets[%v].ttl must be between 0 and 255 got: %v", i, target.TTL)
+		}
+
+		if err := validateStatusFormats(fmt.Sprintf("status.targets[%v].formats", i), target.Formats); err != nil {
+			return err
+		}
+	}
+
+	if config.Events.Enabled && config.Events.Path == "" {
+		return errors.New("events.path cannot be empty")
+	}
+
+	if config.Events.Enabled && config.Events.Buffer < 1 {
+		return fmt.Errorf("events.buffer must be atleast 1 got: %v", config.Events.Buffer)
+	}
+
+	if config.MQTT.Enabled && config.MQTT.Address == "" {
+		return errors.New("mqtt.address cannot be empty")
+	}
+
+	// MQTT 3.1.1 doesn't allow a password without a username
+	if config.MQTT.Password != "" && config.MQTT.Username == "" {
+		return errors.New("mqtt.password requires mqtt.username")
+	}
+
+	if config.MQTT.QoS < 0 || config.MQTT.QoS > 2 {
+		return fmt.Errorf("mqtt.qos must be 0, 1 or 2 got: %v", config.MQTT.QoS)
+	}
+
+	if config.MQTT.Buffer < 1 {
+		return fmt.Errorf("mqtt.buffer must be atleast 1 got: %v", config.MQTT.Buffer)
+	}
+
+	if config.MQTT.KeepAlive > maxMQTTKeepAlive {
+		return fmt.Errorf("mqtt.keep_alive must be at most %v got: %v", maxMQTTKeepAlive, config.MQTT.KeepAlive)
+	}
+
+	if config.Services.MaxRestarts < 0 {
+		return fmt.Errorf("services.max_restarts cannot be negative got: %v", config.Services.MaxRestarts)
+	}
+
+	if err := validateVccChannels(config.Vcc.Channels); err != nil {
+		return err
+	}
+
+	if _, err := ParseLogLevel(config.Log.Level); err != nil {
+		return err
+	}
+
+	intervals := map[string]time.Duration{
+		"modem.command_timeout":          config.Modem.CommandTimeout,
+		"modem.poll_interval":            config.Modem.PollInterval,
+		"modem.retry_interval":           config.Modem.RetryInterval,
+		"modem.preflight_interval":       config.Modem.PreFlightInterval,
+		"status.interval":                config.Status.Interval,
+		"status.feedback_timeout":        config.Status.FeedbackTimeout,
+		"vcc.interval":                   config.Vcc.Interval,
+		"storage.interval":               config.Storage.Interval,
+		"services.interval":              config.Services.Interval,
+		"services.restart_window":        config.Services.RestartWindow,
+		"rimote.interval":                config.Rimote.Interval,
+		"ethernet.interval":              config.Ethernet.Interval,
+		"hostinfo.wait_timeout":          config.HostInfo.WaitTimeout,
+		"watchdog.stall_timeout":         config.Watchdog.StallTimeout,
+		"supervisor.stale_timeout":       config.Supervisor.StaleTimeout,
+		"supervisor.check_interval":      config.Supervisor.CheckInterval,
+		"supervisor.restart_backoff":     config.Supervisor.RestartBackoff,
+		"supervisor.max_restart_backoff": config.Supervisor.MaxRestartBackoff,
+		"mqtt.keep_alive":                config.MQTT.KeepAlive,
+		"mqtt.timeout":                   config.MQTT.Timeout,
+		"mqtt.reconnect_interval":        config.MQTT.ReconnectInterval,
+	}
+
+	for name, interval := range intervals {
+		if interval <= 0 {
+			return fmt.Errorf("%v must be a positive duration got: %v", name, interval)
+		}
+	}
+
+	// A monitor reports once per interval, a longer interval is restarted by the supervisor as stalled
+	monitorIntervals := map[string]time.Duration{
+		"modem.poll_interval":  config.Modem.PollInterval,
+		"modem.retry_interval": config.Modem.RetryInterval,
+		"vcc.interval":         config.Vcc.Interval,
+		"storage.interval":     config.Storage.Interval,
+		"services.interval":    config.Services.Interval,
+		"rimote.interval":      config.Rimote.Interval,
+		"ethernet.interval":    config.Ethernet.Interval,
+	}
+
+	for name, interval := range monitorIntervals {
+		if interval >= config.Supervisor.StaleTimeout {
+			return fmt.Errorf("%v must be below supervisor.stale_timeout: %v got: %v", name, config.Supervisor.StaleTimeout, interval)
+		}
+	}
+
+	for name := range config.Monitors {
+		if !monitorRegistry.Has(name) {
+			return fmt.Errorf("monitors: unknown monitor %v", name)
+		}
+	}
+
+	return nil
+}
+
+func validateVccChannels(channels []VccChannel) error {
+
+	names := make(map[string]bool)
+
+	for i, channel := range channels {
+
+		if channel.Name == "" || names[channel.Name] {
+			return fmt.Errorf("vcc.channels[%v].name must be unique and cannot be empty", i)
+		}
+
+		names[channel.Name] = true
+
+		if (channel.Hwmon == "") == (channel.IIO == "") {
+			return fmt.Errorf("vcc.channels[%v] needs either hwmon or iio", i)
+		}
+
+		if channel.Input == "" {
+			return fmt.Errorf("vcc.channels[%v].input cannot be empty", i)
+		}
+
+		if channel.Max > 0 && channel.Min >= channel.Max {
+			return fmt.Errorf("vcc.channels[%v].min must be below max", i)
+		}
+
+		if channel.Hysteresis < 0 || (channel.Max > 0 && channel.Hysteresis*2 >= channel.Max-channel.Min) {
+			return fmt.Errorf("vcc.channels[%v].hysteresis must be positive and less than half the range", i)
+		}
+	}
+
+	return nil
+}
+
+func validateStatusFormats(name string, formats []string) error {
+
+	for _, format := range formats {
+		if format != StatusFormatLegacy && format != StatusFormatV2 {
+			return fmt.Errorf("%v: unknown format %v", name, format)
+		}
+	}
+
+	return nil
+}
+
+// StatusTargets returns the address followed by the targets, each with its formats filled in
+func (config *StatusConfiguration) StatusTargets() []StatusTarget {
+
+	targets := []StatusTarget{}
+
+	if config.Address != "" {
+		targets = append(targets, StatusTarget{Address: config.Address, Feedback: config.Feedback})
+	}
+
+	targets = append(targets, config.Targets...)
+
+	for i := range targets {
+		if len(targets[i].Formats) == 0 {
+			targets[i].Formats = config.Formats
+		}
+	}
+
+	return targets
+}
+
+// MonitorEnabled returns true unless the monitor is disabled in the configuration
+func (config *Configuration) MonitorEnabled(name string) bool {
+
+	enabled, ok := config.Monitors[name]
+
+	return !ok || enabled
+}
+
+// PortName returns the modem port to use on the current platform
+func (config *ModemConfiguration) PortName() string {
+
+	if IsTargetDevice() {
+		return config.Port
+	}
+
+	return config.DebugPort
+}
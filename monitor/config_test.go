@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseConfigurationKeepsDefaults(t *testing.T) {
+
+	data := []byte(`
+modem:
+  port: /dev/ttyUSB2
+  retry_interval: 1m
+leds:
+  wan_red: 42
+`)
+
+	config, err := ParseConfiguration(data)
+
+	if err != nil {
+		t.Fatalf("Got unexpected error while parsing configuration: %v", err)
+	}
+
+	if config.Modem.Port != "/dev/ttyUSB2" {
+		t.Errorf("Expected modem port: /dev/ttyUSB2 got: %v", config.Modem.Port)
+	}
+
+	if config.Modem.RetryInterval != time.Minute {
+		t.Errorf("Expected retry interval: %v got: %v", time.Minute, config.Modem.RetryInterval)
+	}
+
+	if config.Leds.WanRed != 42 {
+		t.Errorf("Expected wan led gpio: 42 got: %v", config.Leds.WanRed)
+	}
+
+	defaults := DefaultConfiguration()
+
+	if config.Modem.Baud != defaults.Modem.Baud {
+		t.Errorf("Expected default baudrate: %v got: %v", defaults.Modem.Baud, config.Modem.Baud)
+	}
+
+	if config.Status.Address != defaults.Status.Address {
+		t.Errorf("Expected default status address: %v got: %v", defaults.Status.Address, config.Status.Address)
+	}
+
+	if config.Leds.LanRed != LedLanRed {
+		t.Errorf("Expected default lan led gpio: %v got: %v", LedLanRed, config.Leds.LanRed)
+	}
+}
+
+func TestParseConfigurationRejectsInvalid(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "Unknown key", data: "modem:\n  speed: 9600\n"},
+		{name: "Negative interval", data: "status:\n  interval: -2s\n"},
+		{name: "Empty address", data: "status:\n  address: \"\"\n"},
+		{name: "Unknown format", data: "status:\n  formats: [v3]\n"},
+		{name: "Invalid ttl", data: "status:\n  targets:\n  - address: 239.1.2.3:9876\n    ttl: 300\n"},
+		{name: "Invalid qos", data: "mqtt:\n  qos: 3\n"},
+		{name: "Password without username", data: "mqtt:\n  password: secret\n"},
+		{name: "Multicast feedback", data: "status:\n  targets:\n  - address: 239.1.2.3:9876\n    feedback: true\n"},
+		{name: "Vcc without source", data: "vcc:\n  channels:\n  - name: 5v\n    input: in1\n"},
+		{name: "Zero events buffer", data: "events:\n  enabled: true\n  buffer: 0\n"},
+		{name: "Negative max restarts", data: "services:\n  max_restarts: -1\n"},
+		{name: "Interval above stale timeout", data: "storage:\n  interval: 5m\n"},
+		{name: "Interval equal to stale timeout", data: "supervisor:\n  stale_timeout: 30s\nvcc:\n  interval: 30s\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseConfiguration([]byte(tt.data)); err == nil {
+				t.Errorf("Expected an error for configuration: %q", tt.data)
+			}
+		})
+	}
+}
+
+func TestParseConfigurationIgnoresDisabledEvents(t *testing.T) {
+
+	if _, err := ParseConfiguration([]byte("events:\n  enabled: false\n  path: \"\"\n  buffer: 0\n")); err != nil {
+		t.Errorf("Expected the settings of the disabled event socket to be ignored got: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+)
+
+// ConfigurationStore holds the active configuration and allows it to be reloaded while running
+type ConfigurationStore struct {
+	path    string
+	mutex   sync.RWMutex
+	current *Configuration
+	changed chan struct{}
+}
+
+// NewConfigurationStore creates a store with the initial configuration loaded from path
+func NewConfigurationStore(path string, config *Configuration) *ConfigurationStore {
+	return &ConfigurationStore{path: path, current: config, changed: make(chan struct{})}
+}
+
+// Current returns the active configuration, the returned value must be treated as read-only
+func (store *ConfigurationStore) Current() *Configuration {
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.current
+}
+
+// Changed returns a channel which is closed on the next configuration change
+func (store *ConfigurationStore) Changed() <-chan struct{} {
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.changed
+}
+
+// Reload re-reads the configuration file, on errors the active configuration is kept
+func (store *ConfigurationStore) Reload() (*Configuration, error) {
+
+	config, err := LoadConfiguration(store.path)
+
+	if err != nil {
+		return store.Current(), err
+	}
+
+	store.Update(config)
+
+	return config, nil
+}
+
+// Update replaces the active configuration and wakes up everyone waiting for a change
+func (store *ConfigurationStore) Update(config *Configuration) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.current = config
+
+	close(store.changed)
+	store.changed = make(chan struct{})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigurationStoreReload(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "rm-monitor")
+
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "monitor.yaml")
+	store := NewConfigurationStore(path, DefaultConfiguration())
+	changed := store.Changed()
+
+	if err := ioutil.WriteFile(path, []byte("status:\n  interval: 5s\n"), 0644); err != nil {
+		t.Fatalf("Cannot write configuration: %v", err)
+	}
+
+	if _, err := store.Reload(); err != nil {
+		t.Fatalf("Got unexpected error while reloading: %v", err)
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Errorf("Expected the changed channel to be closed after a reload")
+	}
+
+	if store.Current().Status.Interval != 5*time.Second {
+		t.Errorf("Expected status interval: 5s got: %v", store.Current().Status.Interval)
+	}
+
+	// An invalid file must keep the active configuration
+	changed = store.Changed()
+
+	if err := ioutil.WriteFile(path, []byte("status:\n  interval: soon\n"), 0644); err != nil {
+		t.Fatalf("Cannot write configuration: %v", err)
+	}
+
+	if _, err := store.Reload(); err == nil {
+		t.Errorf("Expected an error while reloading an invalid configuration")
+	}
+
+	select {
+	case <-changed:
+		t.Errorf("Expected the changed channel to stay open after a failed reload")
+	default:
+	}
+
+	if store.Current().Status.Interval != 5*time.Second {
+		t.Errorf("Expected status interval to be kept at 5s got: %v", store.Current().Status.Interval)
+	}
+}
@@ -8,9 +8,16 @@ import (
 	"time"
 )
 
-func monitorContext(logger *Logger, cancel func(), signalChannel chan os.Signal) {
+func monitorContext(logger *Logger, store *ConfigurationStore, cancel func(), signalChannel chan os.Signal) {
+
 	s := <-signalChannel
 
+	// Reload our configuration as long as we only receive hang-ups
+	for s == syscall.SIGHUP {
+		reloadConfiguration(logger, store)
+		s = <-signalChannel
+	}
+
 	if IsDebugMode() {
 		logger.DebugF("Got signal: %v invoking cancellation of context", s)
 	}
@@ -24,13 +31,43 @@ func monitorContext(logger *Logger, cancel func(), signalChannel chan os.Signal)
 	cancel()
 }
 
+func reloadConfiguration(logger *Logger, store *ConfigurationStore) {
+
+	logger.Info("Got SIGHUP reloading configuration")
+
+	config, err := store.Reload()
+
+	if err != nil {
+		logger.Errorf("Could not reload configuration, keeping the active one: %v", err)
+		return
+	}
+
+	// Apply our log level directly, the monitors pick up their own changes.
+	if err := logger.SetLevel(config.Log.Level); err != nil {
+		logger.Errorf("Could not apply log level: %v", err)
+	}
+
+	logger.Info("Configuration reloaded")
+}
+
+// sleepWithContext sleeps for the duration, it returns false when the context is cancelled before
+func sleepWithContext(ctx context.Context, duration time.Duration) bool {
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
 func emergencyExit(logger *Logger) {
 	logger.WarningF("Invoked the emergency killer because the process did not shutdown in timely fashion")
 	os.Exit(-101)
 }
 
-// CreateApplicationContext creates context which respects application shutdown
-func CreateApplicationContext(logger *Logger) context.Context {
+// CreateApplicationContext creates context which respects application shutdown and reloads the configuration on SIGHUP
+func CreateApplicationContext(logger *Logger, store *ConfigurationStore) context.Context {
 
 	// Set up channel on which to send signal notifications.
 	// We must use a buffered channel or risk missing the signal
@@ -39,16 +76,16 @@ func CreateApplicationContext(logger *Logger) context.Context {
 
 	// Passing no signals to Notify means that
 	// all signals will be sent to the channel.
-	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	if IsDebugMode() {
-		logger.Debugf("Signal handler installed listening for SIGINT | SIGTERM")
+		logger.Debugf("Signal handler installed listening for SIGINT | SIGTERM | SIGHUP")
 	}
 
 	ctx := context.TODO()
 	ctx, cancel := context.WithCancel(ctx)
 
-	go monitorContext(logger, cancel, signalChannel)
+	go monitorContext(logger, store, cancel, signalChannel)
 
 	return ctx
 }
@@ -24,3 +24,13 @@ func IsDebugMode() bool {
 func IsTraceMode() bool {
 	return os.Getenv("TRACE") != ""
 }
+
+// ConfigurationFilePath returns the configuration file path which can be overridden by the environment
+func ConfigurationFilePath() string {
+
+	if path := os.Getenv("MONITOR_CONFIG"); path != "" {
+		return path
+	}
+
+	return DefaultConfigurationFilePath
+}
@@ -9,14 +9,15 @@ import (
 	"time"
 )
 
-// NewEthernetMonitor create new ethernet monitor
-func NewEthernetMonitor(ctx context.Context, ethernetMessageChannel chan EthernetMessage) *Monitor {
-	monitor := &Monitor{}
-
-	// Run our logic inside a new goroutine.
-	go monitor.run(ctx, ethernetMessageChannel)
+func init() {
+	RegisterMonitor("ethernet", func(dependencies MonitorDependencies) Monitor {
+		return NewEthernetMonitor(dependencies.Store)
+	})
+}
 
-	return monitor
+// NewEthernetMonitor create new ethernet monitor
+func NewEthernetMonitor(store *ConfigurationStore) *EthernetMonitor {
+	return &EthernetMonitor{store: store, events: make(chan MonitorEvent)}
 }
 
 // EthernetMessage type
@@ -28,35 +29,59 @@ type EthernetMessage struct {
 	EthernetConfigured bool
 }
 
-// Monitor type
-type Monitor struct {
+// EthernetMonitor type
+type EthernetMonitor struct {
+	store  *ConfigurationStore
+	events chan MonitorEvent
 }
 
-func (*Monitor) run(ctx context.Context, ethernetMessageChannel chan EthernetMessage) {
+// Name of the monitor
+func (monitor *EthernetMonitor) Name() string {
+	return "ethernet"
+}
 
-	eth0 := &Adapter{Name: "eth0"}
-	eth1 := &Adapter{Name: "eth1"}
-	wifi0 := &Adapter{Name: "wifi0"}
-	ppp0 := &Adapter{Name: "ppp0"}
+// Events returns the EthernetMessage events
+func (monitor *EthernetMonitor) Events() <-chan MonitorEvent {
+	return monitor.events
+}
 
-	defaultTimeout := 15 * time.Second
+// Run the monitor until the context is cancelled
+func (monitor *EthernetMonitor) Run(ctx context.Context) {
 
 	for {
 
+		changed := monitor.store.Changed()
+		config := monitor.store.Current().Ethernet
+
+		eth0 := &Adapter{Name: config.Eth0}
+		eth1 := &Adapter{Name: config.Eth1}
+		wifi0 := &Adapter{Name: config.Wifi}
+		ppp0 := &Adapter{Name: config.Ppp}
+
 		// Refresh our adapter states
 		eth0.update()
 		eth1.update()
 		wifi0.update()
 		ppp0.update()
 
-		ethernetMessageChannel <- EthernetMessage{
-			Eth0:               Adapter{Name: eth0.Name, Connected: eth0.Connected},
-			Eth1:               Adapter{Name: eth1.Name, Connected: eth1.Connected},
-			Wifi0:              Adapter{Name: wifi0.Name, Connected: wifi0.Connected},
-			Ppp0:               Adapter{Name: ppp0.Name, Connected: ppp0.Connected},
+		ethernetMessage := EthernetMessage{
+			Eth0:               *eth0,
+			Eth1:               *eth1,
+			Wifi0:              *wifi0,
+			Ppp0:               *ppp0,
 			EthernetConfigured: eth0.Configured || eth1.Configured}
 
-		time.Sleep(defaultTimeout)
+		if !publishEvent(ctx, monitor.events, monitor.Name(), ethernetMessage) {
+			return
+		}
+
+		// Wait a while, a configuration change will trigger a refresh directly.
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-time.After(config.Interval):
+		}
 	}
 }
 
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventSocketWriteTimeout is the time a client gets to accept a single event
+const eventSocketWriteTimeout = 10 * time.Second
+
+// StatusChangeEvent structure sent as a single json line to the event socket clients
+type StatusChangeEvent struct {
+	Time     time.Time         `json:"time"`
+	Sequence uint64            `json:"sequence"`
+	Changed  map[string]string `json:"changed"`
+	Status   DecodedMessage    `json:"status"`
+}
+
+// statusChanges reports the fields of the status message which changed since the previous message
+type statusChanges struct {
+	fields   map[string]string
+	sequence uint64
+}
+
+// Next returns the changes of the message, nil when nothing changed
+func (changes *statusChanges) Next(msg *Message) *StatusChangeEvent {
+
+	decoded := msg.Decode()
+	fields := make(map[string]string)
+	changed := make(map[string]string)
+
+	for _, field := range decoded.Fields() {
+
+		fields[field[0]] = field[1]
+
+		if changes.fields[field[0]] != field[1] {
+			changed[field[0]] = field[1]
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	changes.sequence++
+	changes.fields = fields
+
+	return &StatusChangeEvent{Time: time.Now(), Sequence: changes.sequence, Changed: changed, Status: decoded}
+}
+
+// EventSocketServer publishes status changes to the clients of a unix socket
+type EventSocketServer struct {
+	logger     *Logger
+	bufferSize int
+
+	mutex   sync.Mutex
+	closed  bool
+	clients map[*eventSocketClient]bool
+	changes statusChanges
+	last    *StatusChangeEvent
+}
+
+type eventSocketClient struct {
+	conn  net.Conn
+	queue chan []byte
+}
+
+// StartEventSocketServer listens on the unix socket until the context is cancelled
+func StartEventSocketServer(ctx context.Context, logger *Logger, config EventSocketConfiguration) (*EventSocketServer, error) {
+
+	// Remove the socket of a previous run, but never another kind of file
+	if info, err := os.Lstat(config.Path); err == nil {
+
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists and is not a socket", config.Path)
+		}
+
+		os.Remove(config.Path)
+	}
+
+	listener, err := listenEventSocket(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	server := &EventSocketServer{
+		logger:     logger,
+		bufferSize: config.Buffer,
+		clients:    make(map[*eventSocketClient]bool),
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		os.Remove(config.Path)
+		server.closeClients()
+	}()
+
+	go server.accept(listener)
+
+	if IsDebugMode() {
+		logger.Debugf("Event socket listening @ %v", config.Path)
+	}
+
+	return server, nil
+}
+
+// listenEventSocket binds the socket in a private directory and moves it in place after the permissions are applied,
+// so no one can connect to it with the permissions of the umask
+func listenEventSocket(config EventSocketConfiguration) (net.Listener, error) {
+
+	dir, err := ioutil.TempDir(filepath.Dir(config.Path), ".rm-monitor")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, filepath.Base(config.Path))
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket is moved, it's removed at shutdown instead
+	listener.SetUnlinkOnClose(false)
+
+	if err := restrictEventSocket(path, config); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if err := os.Rename(path, config.Path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// restrictEventSocket applies the file permissions, only users with write access can connect
+func restrictEventSocket(path string, config EventSocketConfiguration) error {
+
+	if config.Group != "" {
+
+		group, err := user.LookupGroup(config.Group)
+
+		if err != nil {
+			return err
+		}
+
+		gid, err := strconv.Atoi(group.Gid)
+
+		if err != nil {
+			return err
+		}
+
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+
+	return os.Chmod(path, config.Mode)
+}
+
+func (server *EventSocketServer) accept(listener net.Listener) {
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		client := &eventSocketClient{conn: conn, queue: make(chan []byte, server.bufferSize)}
+
+		server.mutex.Lock()
+
+		// A client accepted during the shutdown is never served
+		if server.closed {
+			server.mutex.Unlock()
+			conn.Close()
+			return
+		}
+
+		// Every client starts with the current state
+		if server.last != nil {
+			event := *server.last
+			event.Changed = server.changes.fields
+			client.queue <- encodeStatusChangeEvent(&event)
+		}
+
+		server.clients[client] = true
+		metrics.EventClients.Set(float64(len(server.clients)))
+
+		server.mutex.Unlock()
+
+		go server.write(client)
+	}
+}
+
+func (server *EventSocketServer) write(client *eventSocketClient) {
+
+	for line := range client.queue {
+
+		client.conn.SetWriteDeadline(time.Now().Add(eventSocketWriteTimeout))
+
+		if _, err := client.conn.Write(line); err != nil {
+			server.remove(client)
+			return
+		}
+	}
+}
+
+// remove disconnects the client
+func (server *EventSocketServer) remove(client *eventSocketClient) {
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.removeLocked(client)
+}
+
+func (server *EventSocketServer) removeLocked(client *eventSocketClient) {
+
+	if !server.clients[client] {
+		return
+	}
+
+	delete(server.clients, client)
+	close(client.queue)
+	client.conn.Close()
+	metrics.EventClients.Set(float64(len(server.clients)))
+}
+
+func (server *EventSocketServer) closeClients() {
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.closed = true
+
+	for client := range server.clients {
+		server.removeLocked(client)
+	}
+}
+
+// Publish sends the changed fields of the message to every client, it never blocks
+func (server *EventSocketServer) Publish(msg *Message) {
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	event := server.changes.Next(msg)
+
+	if event == nil {
+		return
+	}
+
+	server.last = event
+
+	line := encodeStatusChangeEvent(server.last)
+
+	for client := range server.clients {
+		select {
+		case client.queue <- line:
+		default:
+			// A slow client is dropped, it gets the full state again when it reconnects
+			server.logger.Warningf("Dropping event socket client which is %v events behind", server.bufferSize)
+			metrics.EventClientsDropped.Inc()
+			server.removeLocked(client)
+		}
+	}
+}
+
+func encodeStatusChangeEvent(event *StatusChangeEvent) []byte {
+
+	line, _ := json.Marshal(event)
+
+	return append(line, '\n')
+}
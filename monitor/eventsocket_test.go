@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readStatusChangeEvent(t *testing.T, reader *bufio.Reader) StatusChangeEvent {
+
+	line, err := reader.ReadBytes('\n')
+
+	if err != nil {
+		t.Fatalf("Cannot read event: %v", err)
+	}
+
+	event := StatusChangeEvent{}
+
+	if err := json.Unmarshal(line, &event); err != nil {
+		t.Fatalf("Cannot decode event: %v %s", err, line)
+	}
+
+	return event
+}
+
+func TestEventSocketServer(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "rm-monitor")
+
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := DefaultConfiguration().Events
+	config.Path = filepath.Join(dir, "monitor.sock")
+
+	server, err := StartEventSocketServer(ctx, logger, config)
+
+	if err != nil {
+		t.Fatalf("Cannot start event socket: %v", err)
+	}
+
+	if info, err := os.Stat(config.Path); err != nil || info.Mode().Perm() != 0660 {
+		t.Errorf("Expected socket with mode 0660 got: %v error: %v", info, err)
+	}
+
+	// The private directory the socket is bound in is removed
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Errorf("Expected only the socket in: %v got: %v error: %v", dir, len(files), err)
+	}
+
+	msg := NewMessage()
+	server.Publish(msg)
+
+	conn, err := net.Dial("unix", config.Path)
+
+	if err != nil {
+		t.Fatalf("Cannot connect to event socket: %v", err)
+	}
+
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// The client starts with every field
+	if event := readStatusChangeEvent(t, reader); len(event.Changed) != len(msg.Decode().Fields()) {
+		t.Errorf("Expected the initial event to contain every field got: %v", event.Changed)
+	}
+
+	// Without changes nothing is published
+	server.Publish(msg)
+
+	msg.ConnectionStatus().SetEth0Status(true)
+	server.Publish(msg)
+
+	event := readStatusChangeEvent(t, reader)
+
+	if expected := map[string]string{"connection.eth0": "true"}; !reflect.DeepEqual(event.Changed, expected) {
+		t.Errorf("Expected changes: %v got: %v", expected, event.Changed)
+	}
+
+	if !event.Status.Connection.Eth0 || event.Sequence != 2 {
+		t.Errorf("Expected sequence 2 with eth0 up got: %+v", event)
+	}
+}
+
+func TestEventSocketServerDropsSlowClients(t *testing.T) {
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	server := &EventSocketServer{logger: logger, bufferSize: 1, clients: make(map[*eventSocketClient]bool)}
+
+	// Without a writer the queue is never drained
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	client := &eventSocketClient{conn: local, queue: make(chan []byte, 1)}
+	server.clients[client] = true
+
+	msg := NewMessage()
+	server.Publish(msg)
+	msg.ConnectionStatus().SetEth1Status(true)
+	server.Publish(msg)
+
+	if len(server.clients) != 0 {
+		t.Errorf("Expected the slow client to be dropped")
+	}
+}
+
+// eventSocketTestListener accepts the queued connections, it fails when the queue is closed
+type eventSocketTestListener struct {
+	conns chan net.Conn
+}
+
+func (listener *eventSocketTestListener) Accept() (net.Conn, error) {
+
+	conn, ok := <-listener.conns
+
+	if !ok {
+		return nil, errors.New("listener closed")
+	}
+
+	return conn, nil
+}
+
+func (listener *eventSocketTestListener) Close() error {
+	return nil
+}
+
+func (listener *eventSocketTestListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "test", Net: "unix"}
+}
+
+func TestEventSocketServerRejectsClientsAfterClose(t *testing.T) {
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	server := &EventSocketServer{logger: logger, bufferSize: 1, clients: make(map[*eventSocketClient]bool)}
+	server.closeClients()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	listener := &eventSocketTestListener{conns: make(chan net.Conn, 1)}
+	listener.conns <- local
+	close(listener.conns)
+
+	server.accept(listener)
+
+	if len(server.clients) != 0 {
+		t.Errorf("Expected no clients after the server is closed got: %v", len(server.clients))
+	}
+
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := remote.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the client to be disconnected got: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Heartbeats keeps track of the last time every subsystem made progress
+type Heartbeats struct {
+	mutex sync.Mutex
+	beats map[string]time.Time
+}
+
+// NewHeartbeats creates heartbeats for the given subsystems, they count as alive from now on
+func NewHeartbeats(names ...string) *Heartbeats {
+
+	heartbeats := &Heartbeats{beats: make(map[string]time.Time)}
+
+	for _, name := range names {
+		heartbeats.Beat(name)
+	}
+
+	return heartbeats
+}
+
+// Beat records progress of the subsystem
+func (heartbeats *Heartbeats) Beat(name string) {
+
+	heartbeats.mutex.Lock()
+	defer heartbeats.mutex.Unlock()
+
+	heartbeats.beats[name] = time.Now()
+}
+
+// LastBeat returns the last time the subsystem made progress
+func (heartbeats *Heartbeats) LastBeat(name string) (time.Time, bool) {
+
+	heartbeats.mutex.Lock()
+	defer heartbeats.mutex.Unlock()
+
+	beat, ok := heartbeats.beats[name]
+	return beat, ok
+}
+
+// Stale returns the sorted names of the subsystems without progress within maxAge
+func (heartbeats *Heartbeats) Stale(maxAge time.Duration) []string {
+
+	heartbeats.mutex.Lock()
+	defer heartbeats.mutex.Unlock()
+
+	stale := []string{}
+	now := time.Now()
+
+	for name, beat := range heartbeats.beats {
+		if now.Sub(beat) > maxAge {
+			stale = append(stale, name)
+		}
+	}
+
+	sort.Strings(stale)
+
+	return stale
+}
@@ -12,23 +12,90 @@ import (
 	"time"
 )
 
-// DeviceFirmwareFilePath The path of the file containing the firmware version info
+// DeviceFirmwareFilePath The default path of the file containing the firmware version info
 const DeviceFirmwareFilePath string = "/etc/mender/artifact_info"
 
-// DeviceRimoteInfoFilePath The path of the file containing the firmware version info
+// DeviceRimoteInfoFilePath The default path of the file containing the firmware version info
 const DeviceRimoteInfoFilePath string = "/usr/share/Riwo/Rimote/HostInfo.txt"
 
-// SystemConfigurationFilePath The path of the file containing the system parameters
+// SystemConfigurationFilePath The default path of the file containing the system parameters
 const SystemConfigurationFilePath string = "/data/system/configuration.xml"
 
-// FactorySystemConfigurationFilePath The path of the file containing the system parameters (factory-default)
+// FactorySystemConfigurationFilePath The default path of the file containing the system parameters (factory-default)
 const FactorySystemConfigurationFilePath string = "/usr/local/rimote/riwo.rimote-management/app/factory.xml"
 
+func init() {
+	RegisterMonitor("hostinfo", func(dependencies MonitorDependencies) Monitor {
+		return &hostInfoMonitor{
+			logger:   dependencies.Logger,
+			store:    dependencies.Store,
+			recorder: dependencies.Recorder,
+			input:    make(chan HostInfo, 1)}
+	})
+}
+
+// hostInfoMonitor writes the host info file based on the modem events
+type hostInfoMonitor struct {
+	logger   *Logger
+	store    *ConfigurationStore
+	recorder *StatusRecorder
+	input    chan HostInfo
+}
+
+// Name of the monitor
+func (monitor *hostInfoMonitor) Name() string {
+	return "hostinfo"
+}
+
+// Events returns nil because we only consume events
+func (monitor *hostInfoMonitor) Events() <-chan MonitorEvent {
+	return nil
+}
+
+// Run the monitor until the context is cancelled
+func (monitor *hostInfoMonitor) Run(ctx context.Context) {
+	HandleHostInfo(ctx, monitor.logger, monitor.store, monitor.recorder, monitor.input)
+}
+
+// Observe passes the modem info to the writer, only the latest info is kept when we're busy
+func (monitor *hostInfoMonitor) Observe(event MonitorEvent) {
+
+	modemMessage, ok := event.Payload.(ModemStatusMessage)
+
+	if !ok {
+		return
+	}
+
+	hostInfo := HostInfo{
+		ModemEnabled: modemMessage.ModemAvailable,
+		SimID:        modemMessage.SimUccid,
+		Registration: modemMessage.Registration,
+		Operator:     modemMessage.Operator,
+		MCC:          modemMessage.MCC,
+		MNC:          modemMessage.MNC,
+	}
+
+	select {
+	case monitor.input <- hostInfo:
+	default:
+		// Replace the pending info with our latest
+		select {
+		case <-monitor.input:
+		default:
+		}
+		monitor.input <- hostInfo
+	}
+}
+
 // HostInfo structure
 type HostInfo struct {
 	FirmwareVersion string
 	ModemEnabled    bool
 	SimID           string
+	Registration    RegistrationState
+	Operator        string
+	MCC             string
+	MNC             string
 	HasInfo         bool
 }
 
@@ -49,7 +116,7 @@ func (hostInfo *HostInfo) UpdateInfoPresent() bool {
 }
 
 // UpdateModemInfo updates the modem info and returns true if the modem info is updated
-func (hostInfo *HostInfo) UpdateModemInfo(newInfo HostInfo) bool {
+func (hostInfo *HostInfo) UpdateModemInfo(newInfo HostInfo, usingFactoryConfig bool) bool {
 
 	updated := hostInfo.UpdateInfoPresent()
 
@@ -64,60 +131,80 @@ func (hostInfo *HostInfo) UpdateModemInfo(newInfo HostInfo) bool {
 	if newInfo.SimID != "" && hostInfo.SimID != newInfo.SimID {
 
 		// Only update our sim-id, if we're not using the system factory config.
-		if !DeviceIsUsingFactoryConfig() {
+		if !usingFactoryConfig {
 			hostInfo.SimID = newInfo.SimID
 			updated = true
 		}
 	}
 
+	// A modem which is offline or removed has no registration
+	if !newInfo.ModemEnabled {
+		newInfo.Registration = ""
+		newInfo.Operator = ""
+		newInfo.MCC = ""
+		newInfo.MNC = ""
+	}
+
+	// The operator is only known when registered, so it's updated with the registration. A modem which just
+	// connected reports the registration with the next poll.
+	if (newInfo.Registration != "" || !newInfo.ModemEnabled) && (hostInfo.Registration != newInfo.Registration ||
+		hostInfo.Operator != newInfo.Operator || hostInfo.MCC != newInfo.MCC || hostInfo.MNC != newInfo.MNC) {
+		hostInfo.Registration = newInfo.Registration
+		hostInfo.Operator = newInfo.Operator
+		hostInfo.MCC = newInfo.MCC
+		hostInfo.MNC = newInfo.MNC
+		updated = true
+	}
+
 	return updated
 }
 
-// HandleHostInfo handles host info writes
-func HandleHostInfo(ctx context.Context, logger *Logger, hostInfoInputChannel <-chan HostInfo) {
+// HandleHostInfo handles host info writes until the context is cancelled
+func HandleHostInfo(ctx context.Context, logger *Logger, store *ConfigurationStore, recorder *StatusRecorder, hostInfoInputChannel <-chan HostInfo) {
 
-	// Handle inside go routine
-	go func() {
+	config := store.Current().HostInfo
 
-		// Run some tests
-		runUpfronConfigurationChecks(logger)
+	// Run some tests
+	runUpfronConfigurationChecks(logger, &config)
 
-		emptyInfo := HostInfo{}
-		firmwareVersion, _ := GetFirmwareVersion()
-		current := &HostInfo{FirmwareVersion: firmwareVersion}
+	emptyInfo := HostInfo{}
+	firmwareVersion, _ := GetFirmwareVersion(config.FirmwareFile)
+	current := &HostInfo{FirmwareVersion: firmwareVersion}
 
-		// Initial select case where we want to wait max 45 seconds before giving up and writing the result anyway without sim-info
+	// Initial select case where we want to wait a limited time before giving up and writing the result anyway without sim-info
+	select {
+	// Context exit requested
+	case <-ctx.Done():
+		return
+	// We got hostinfo before the timeout
+	case hostmsg := <-hostInfoInputChannel:
+		writeInternal(logger, store, current, hostmsg, false)
+		recorder.RecordHostInfo(*current)
+	// We got no hostinfo before the timeout force the current one with only firmware information
+	case <-time.After(config.WaitTimeout):
+		writeInternal(logger, store, current, emptyInfo, true)
+		recorder.RecordHostInfo(*current)
+	}
+
+	// Further case where we only want to write, if we got a new sim-id
+	for {
 		select {
-		// Context exit requested
 		case <-ctx.Done():
 			return
-		// We got hostinfo before the timeout
 		case hostmsg := <-hostInfoInputChannel:
-			writeInternal(logger, current, hostmsg, false)
-		// We got no hostinfo before the timeout force the current one with only firmware information
-		case <-time.After(45 * time.Second):
-			writeInternal(logger, current, emptyInfo, true)
-		}
-
-		// Further case where we only want to write, if we got a new sim-id
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case hostmsg := <-hostInfoInputChannel:
-				writeInternal(logger, current, hostmsg, false)
-			}
+			writeInternal(logger, store, current, hostmsg, false)
+			recorder.RecordHostInfo(*current)
 		}
-	}()
+	}
 }
 
-func runUpfronConfigurationChecks(logger *Logger) {
+func runUpfronConfigurationChecks(logger *Logger, config *HostInfoConfiguration) {
 
-	if err := FactoryFilePresent(); err != nil {
+	if err := FactoryFilePresent(config); err != nil {
 		logger.WarningF("The factory system configuration file is not present error: %v", err)
 	}
 
-	if err := SystemConfigFilePresent(); err != nil {
+	if err := SystemConfigFilePresent(config); err != nil {
 		logger.WarningF("The system configuration file is not present error: %v", err)
 	}
 
@@ -126,12 +213,12 @@ func runUpfronConfigurationChecks(logger *Logger) {
 
 	var err error
 
-	if factorySha1, err = GetSha1SumFromFile(FactorySystemConfigurationFilePath); err != nil {
+	if factorySha1, err = GetSha1SumFromFile(config.FactoryConfigFile); err != nil {
 		logger.WarningF("Cannot determina sha1 for factory system config file: %v", err)
 		return
 	}
 
-	if configFileSha1, err = GetSha1SumFromFile(SystemConfigurationFilePath); err != nil {
+	if configFileSha1, err = GetSha1SumFromFile(config.SystemConfigFile); err != nil {
 		logger.WarningF("Cannot determina sha1 for system config file: %v", err)
 		return
 	}
@@ -148,26 +235,35 @@ func runUpfronConfigurationChecks(logger *Logger) {
 }
 
 // GetFirmwareVersion return the firmware version from the Firmware
-func GetFirmwareVersion() (string, error) {
+func GetFirmwareVersion(path string) (string, error) {
 
 	if !IsTargetDevice() {
 		return "[DEVELOPMENT]", nil
 	}
 
-	databytes, err := ioutil.ReadFile(DeviceFirmwareFilePath)
-	version := strings.TrimSpace(strings.Split(string(databytes), "=")[1])
+	databytes, err := ioutil.ReadFile(path)
 
 	if err != nil {
 		return "[UNDECTABLE]", err
 	}
 
-	return version, nil
+	items := strings.SplitN(string(databytes), "=", 2)
+
+	if len(items) != 2 {
+		return "[UNDECTABLE]", fmt.Errorf("no version in firmware file: %v", path)
+	}
+
+	// Only the first line holds the version
+	return strings.TrimSpace(strings.SplitN(items[1], "\n", 2)[0]), nil
 }
 
-func writeInternal(logger *Logger, currentInfo *HostInfo, newInfo HostInfo, forced bool) {
+func writeInternal(logger *Logger, store *ConfigurationStore, currentInfo *HostInfo, newInfo HostInfo, forced bool) {
+
+	config := &store.Current().HostInfo
+	usingFactoryConfig := DeviceIsUsingFactoryConfig(config)
 
 	// Update properties where applicable
-	infoUpdated := currentInfo.UpdateModemInfo(newInfo)
+	infoUpdated := currentInfo.UpdateModemInfo(newInfo, usingFactoryConfig)
 
 	// Write debug/verbose logging.
 	if infoUpdated && IsDebugMode() {
@@ -178,13 +274,13 @@ func writeInternal(logger *Logger, currentInfo *HostInfo, newInfo HostInfo, forc
 	if forced || infoUpdated {
 
 		// Log that we are writing the rimote status info
-		logger.InfoF("Writing rimote connection info [forced: %v] [factory-config: %v]", forced, DeviceIsUsingFactoryConfig())
+		logger.InfoF("Writing rimote connection info [forced: %v] [factory-config: %v]", forced, usingFactoryConfig)
 
 		// Perform the actual write
-		err := WriteRimoteInfo(DeviceRimoteInfoFilePath, currentInfo)
+		err := WriteRimoteInfo(config.RimoteInfoFile, currentInfo)
 
 		if err != nil {
-			logger.Errorf("Could not write Rimote info file @ path: %v error: %v", DeviceRimoteInfoFilePath, err)
+			logger.Errorf("Could not write Rimote info file @ path: %v error: %v", config.RimoteInfoFile, err)
 		}
 	}
 }
@@ -193,6 +289,8 @@ func writeHostInfoToLogger(logger *Logger, hostInfo *HostInfo) {
 	logger.DebugF("HostmodemInfo[FirmwareVersion]: %v", hostInfo.FirmwareVersion)
 	logger.DebugF("HostmodemInfo[ModemEnabled]: %v", hostInfo.ModemEnabled)
 	logger.DebugF("HostmodemInfo[SimID]: %v", hostInfo.SimID)
+	logger.DebugF("HostmodemInfo[Registration]: %v", hostInfo.Registration)
+	logger.DebugF("HostmodemInfo[Operator]: %v (%v/%v)", hostInfo.Operator, hostInfo.MCC, hostInfo.MNC)
 }
 
 func checkWrite(hostInfo *HostInfo) bool {
@@ -201,6 +299,11 @@ func checkWrite(hostInfo *HostInfo) bool {
 
 // WriteRimoteInfo writes HostDeviceInfo file
 func WriteRimoteInfo(path string, hostInfo *HostInfo) error {
+	return ioutil.WriteFile(path, FormatRimoteInfo(hostInfo), 0666)
+}
+
+// FormatRimoteInfo returns the content of the HostDeviceInfo file
+func FormatRimoteInfo(hostInfo *HostInfo) []byte {
 
 	db := make([]byte, 0)
 	buffer := bytes.NewBuffer(db)
@@ -211,13 +314,24 @@ func WriteRimoteInfo(path string, hostInfo *HostInfo) error {
 		fmt.Fprintln(buffer, fmt.Sprintf("sim-number: %v", hostInfo.SimID))
 	}
 
-	data := buffer.Bytes()
-	dataBytes := []byte(data)
-	return ioutil.WriteFile(path, dataBytes, 0666)
+	if hostInfo.Registration != "" {
+		fmt.Fprintln(buffer, fmt.Sprintf("network-registration: %v", hostInfo.Registration))
+	}
+
+	if hostInfo.Operator != "" {
+		fmt.Fprintln(buffer, fmt.Sprintf("network-operator: %v", hostInfo.Operator))
+	}
+
+	if hostInfo.MCC != "" {
+		fmt.Fprintln(buffer, fmt.Sprintf("network-mcc: %v", hostInfo.MCC))
+		fmt.Fprintln(buffer, fmt.Sprintf("network-mnc: %v", hostInfo.MNC))
+	}
+
+	return buffer.Bytes()
 }
 
 // DeviceIsUsingFactoryConfig return true if this device is using the factory config
-func DeviceIsUsingFactoryConfig() bool {
+func DeviceIsUsingFactoryConfig(config *HostInfoConfiguration) bool {
 
 	// Return false in debug mode on device.
 	if !IsTargetDevice() {
@@ -225,17 +339,17 @@ func DeviceIsUsingFactoryConfig() bool {
 	}
 
 	// Without a factory file we can't do comparison
-	if FactoryFilePresent() != nil {
+	if FactoryFilePresent(config) != nil {
 		return false
 	}
 
 	// Without a system configuration consider our self valid
-	if SystemConfigFilePresent() != nil {
+	if SystemConfigFilePresent(config) != nil {
 		return true
 	}
 
-	factorySha1, _ := GetSha1SumFromFile(FactorySystemConfigurationFilePath)
-	configFileSha1, _ := GetSha1SumFromFile(SystemConfigurationFilePath)
+	factorySha1, _ := GetSha1SumFromFile(config.FactoryConfigFile)
+	configFileSha1, _ := GetSha1SumFromFile(config.SystemConfigFile)
 
 	// Run sha1 comparison
 	if factorySha1 == configFileSha1 {
@@ -246,14 +360,14 @@ func DeviceIsUsingFactoryConfig() bool {
 }
 
 // FactoryFilePresent check if the factory file is present and return nil when everyting works like expected
-func FactoryFilePresent() error {
-	_, err := os.Stat(FactorySystemConfigurationFilePath)
+func FactoryFilePresent(config *HostInfoConfiguration) error {
+	_, err := os.Stat(config.FactoryConfigFile)
 	return err
 }
 
 // SystemConfigFilePresent check if the factory file is present and return nil when everyting works like expected
-func SystemConfigFilePresent() error {
-	_, err := os.Stat(SystemConfigurationFilePath)
+func SystemConfigFilePresent(config *HostInfoConfiguration) error {
+	_, err := os.Stat(config.SystemConfigFile)
 	return err
 }
 
@@ -39,6 +39,48 @@ func TestFullWriteRimoteInfo(t *testing.T) {
 	}
 }
 
+func TestUpdateModemInfoRegistration(t *testing.T) {
+
+	hostinfo := &HostInfo{HasInfo: true, ModemEnabled: true}
+	roaming := HostInfo{ModemEnabled: true, Registration: RegistrationRoaming, Operator: "Vodafone NL", MCC: "204", MNC: "04"}
+
+	if !hostinfo.UpdateModemInfo(roaming, false) || hostinfo.Operator != "Vodafone NL" {
+		t.Errorf("Expected the roaming registration to be updated got: %+v", hostinfo)
+	}
+
+	if hostinfo.UpdateModemInfo(roaming, false) {
+		t.Errorf("Expected no update for the same registration")
+	}
+
+	// A modem which just connected reports the registration with the next poll
+	if hostinfo.UpdateModemInfo(HostInfo{ModemEnabled: true}, false) || hostinfo.Registration != RegistrationRoaming {
+		t.Errorf("Expected the registration to be kept got: %+v", hostinfo)
+	}
+
+	if !hostinfo.UpdateModemInfo(HostInfo{ModemEnabled: true, Registration: RegistrationSearching}, false) || hostinfo.Operator != "" || hostinfo.MCC != "" {
+		t.Errorf("Expected the operator to be cleared while searching got: %+v", hostinfo)
+	}
+
+	hostinfo.UpdateModemInfo(roaming, false)
+
+	// A removed modem has no registration
+	if !hostinfo.UpdateModemInfo(HostInfo{}, false) || hostinfo.Registration != "" || hostinfo.Operator != "" || hostinfo.MCC != "" {
+		t.Errorf("Expected the registration to be cleared for an offline modem got: %+v", hostinfo)
+	}
+
+	if text := string(FormatRimoteInfo(hostinfo)); strings.Contains(text, "network-") {
+		t.Errorf("Expected no network info for an offline modem got: %q", text)
+	}
+
+	text := string(FormatRimoteInfo(&HostInfo{Registration: RegistrationHome, Operator: "KPN NL", MCC: "204", MNC: "08"}))
+
+	for _, expected := range []string{"network-registration: home\n", "network-operator: KPN NL\n", "network-mcc: 204\n", "network-mnc: 08\n"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Missing: %q in: %q", expected, text)
+		}
+	}
+}
+
 /*
 func TestWriteRimoteInfoWithModemAvailable(t *testing.T) {
 
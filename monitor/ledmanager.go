@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 )
 
 // LedState Type
@@ -22,10 +23,12 @@ const (
 
 var gpioMapping map[ManagerGpio]Pin
 var errGpioNotInitialized = errors.New("gpio not inialized")
+var ledConfiguration = DefaultLedConfiguration()
 
 // ManagerGpio type
 type ManagerGpio uint
 
+// Default gpio numbers, these can be overridden by the leds configuration
 const (
 	// LedPowerRed led power led
 	LedPowerRed ManagerGpio = 135
@@ -71,14 +74,50 @@ const (
 	Wifi SystemLed = 5
 )
 
+// ConfigureLeds sets the gpio mapping to use, pins which are already exported are reused
+func ConfigureLeds(config LedConfiguration) {
+
+	ledConfiguration = config
+
+	// Only export the new pins when we're already setup.
+	if gpioMapping != nil {
+		exportLedPins(config)
+	}
+}
+
+func (config LedConfiguration) outputs() []ManagerGpio {
+
+	return []ManagerGpio{
+		config.PowerBlue,
+		config.PowerGreen,
+		config.WanRed,
+		config.LanRed,
+		config.WifiRed,
+		config.WifiGreen,
+		config.WifiBlue,
+		config.BroadbandRed,
+		config.BroadbandGreen,
+		config.BroadbandBlue,
+	}
+}
+
+func exportLedPins(config LedConfiguration) {
+
+	for _, gpio := range config.outputs() {
+		if _, ok := gpioMapping[gpio]; !ok {
+			gpioMapping[gpio] = NewOutput(uint(gpio), true)
+		}
+	}
+}
+
 // SetEth0Led set the ethernet led according to the state
 func SetEth0Led(configured bool, connected bool) error {
-	return setEthernetLed(LedWanRed, configured, connected)
+	return setEthernetLed(ledConfiguration.WanRed, configured, connected)
 }
 
 // SetEth1Led set the ethernet led according to the state
 func SetEth1Led(configured bool, connected bool) error {
-	return setEthernetLed(LedLanRed, configured, connected)
+	return setEthernetLed(ledConfiguration.LanRed, configured, connected)
 }
 
 func setEthernetLed(gpio ManagerGpio, configured bool, connected bool) error {
@@ -104,7 +143,7 @@ func setEthernetLed(gpio ManagerGpio, configured bool, connected bool) error {
 func SetRimoteLed(connected bool) error {
 
 	// Set blue led on
-	err := gpioFunc(LedPowerBlue, func(bpin Pin) error {
+	err := gpioFunc(ledConfiguration.PowerBlue, func(bpin Pin) error {
 
 		if connected {
 			return bpin.High()
@@ -118,7 +157,7 @@ func SetRimoteLed(connected bool) error {
 	}
 
 	// Set led green inverted
-	err = gpioFunc(LedPowerGreen, func(gpin Pin) error {
+	err = gpioFunc(ledConfiguration.PowerGreen, func(gpin Pin) error {
 
 		if connected {
 			return gpin.Low()
@@ -132,12 +171,12 @@ func SetRimoteLed(connected bool) error {
 
 // SetWifiLed sets the wifi led
 func SetWifiLed(strength SignalStrength) error {
-	return SignalStrengthToGpio(LedWifiRed, LedWifiGreen, LedWifiBlue, strength)
+	return SignalStrengthToGpio(ledConfiguration.WifiRed, ledConfiguration.WifiGreen, ledConfiguration.WifiBlue, strength)
 }
 
 // SetBroadbandLed sets the broadband led
 func SetBroadbandLed(strength SignalStrength) error {
-	return SignalStrengthToGpio(LedBroadbandRed, LedBroadbandGreen, LedBroadbandBlue, strength)
+	return SignalStrengthToGpio(ledConfiguration.BroadbandRed, ledConfiguration.BroadbandGreen, ledConfiguration.BroadbandBlue, strength)
 }
 
 func gpioFunc(gpio ManagerGpio, fn func(Pin) error) error {
@@ -240,22 +279,36 @@ func setup() error {
 		return nil
 	}
 
-	gpioMapping = map[ManagerGpio]Pin{
-		LedPowerBlue:      NewOutput(uint(LedPowerBlue), true),
-		LedPowerGreen:     NewOutput(uint(LedPowerGreen), true),
-		LedWanRed:         NewOutput(uint(LedWanRed), true),
-		LedLanRed:         NewOutput(uint(LedLanRed), true),
-		LedWifiRed:        NewOutput(uint(LedWifiRed), true),
-		LedWifiGreen:      NewOutput(uint(LedWifiGreen), true),
-		LedWifiBlue:       NewOutput(uint(LedWifiBlue), true),
-		LedBroadbandRed:   NewOutput(uint(LedBroadbandRed), true),
-		LedBroadbandGreen: NewOutput(uint(LedBroadbandGreen), true),
-		LedBroadbandBlue:  NewOutput(uint(LedBroadbandBlue), true),
-	}
+	gpioMapping = map[ManagerGpio]Pin{}
+	exportLedPins(ledConfiguration)
 
 	return nil
 }
 
+// ShutdownLeds drives every led off to show the service is stopped, then closes and unexports the pins
+func ShutdownLeds() error {
+
+	if gpioMapping == nil {
+		return nil
+	}
+
+	var firstErr error
+
+	for gpio, pin := range gpioMapping {
+
+		if err := pin.Low(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("gpio %v: %v", gpio, err)
+		}
+
+		pin.Close()
+		unexportGPIO(pin)
+	}
+
+	gpioMapping = nil
+
+	return firstErr
+}
+
 // GetPin returns the pin if exported
 func GetPin(managerGpio ManagerGpio) (Pin, error) {
 
@@ -72,6 +72,17 @@ type Info struct {
 type Logger struct {
 	Module string
 	worker *Worker
+	level  int32
+}
+
+// Log levels ordered by importance, messages below the logger level are discarded
+var logLevels = map[string]int32{
+	"DEBUG":    0,
+	"INFO":     1,
+	"NOTICE":   2,
+	"WARNING":  3,
+	"ERROR":    4,
+	"CRITICAL": 5,
 }
 
 // init pkg
@@ -261,7 +272,35 @@ func (l *Logger) Log(lvl string, message string) {
 	l.log_internal(lvl, message, 2)
 }
 
+// ParseLogLevel returns the numeric log level for the given level name
+func ParseLogLevel(name string) (int32, error) {
+
+	level, ok := logLevels[strings.ToUpper(name)]
+
+	if !ok {
+		return 0, fmt.Errorf("unknown log level: %v", name)
+	}
+
+	return level, nil
+}
+
+// SetLevel sets the minimum level of the messages which are logged
+func (l *Logger) SetLevel(name string) error {
+
+	level, err := ParseLogLevel(name)
+
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&l.level, level)
+	return nil
+}
+
 func (l *Logger) log_internal(lvl string, message string, pos int) {
+	if logLevels[lvl] < atomic.LoadInt32(&l.level) {
+		return
+	}
 	//var formatString string = "#%d %s [%s] %s:%d ▶ %.3s %s"
 	_, filename, line, _ := runtime.Caller(pos)
 	filename = path.Base(filename)
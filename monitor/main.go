@@ -3,18 +3,15 @@ package main
 import (
 	"context"
 	"os"
+	"strings"
 	"time"
 )
 
 func main() {
+	os.Exit(RunCommandLine(os.Args[1:]))
+}
 
-	// First create a logger.
-	log, err := New("test", 1, os.Stdout)
-
-	// Check if we're allowed to stdout.
-	if err != nil {
-		panic(err)
-	}
+func runDaemon(log *Logger, store *ConfigurationStore) {
 
 	/*go func() {
 		http.ListenAndServe("localhost:6060", nil)
@@ -23,8 +20,16 @@ func main() {
 
 	*/
 
+	config := store.Current()
+
+	if err := log.SetLevel(config.Log.Level); err != nil {
+		log.Warningf("Could not apply log level: %v", err)
+	}
+
+	ConfigureLeds(config.Leds)
+
 	// Create a context this allows to shutdown gracefully.
-	ctx := CreateApplicationContext(log)
+	ctx := CreateApplicationContext(log, store)
 
 	// Log we're starting.
 	if IsDebugMode() {
@@ -33,28 +38,88 @@ func main() {
 		log.Info("Monitor started")
 	}
 
-	// Create the channels
-	monitorChannel := CreateMonitorChannel()
+	// Keep track of our state for the status api and the systemd watchdog
+	recorder := NewStatusRecorder()
+	notifier := NewSystemdNotifier()
+	heartbeats := NewHeartbeats("messageloop")
+	supervisor := NewSupervisor(monitorRegistry, MonitorDependencies{Logger: log, Store: store, Recorder: recorder}, config.MonitorEnabled, heartbeats)
+
+	RunWatchdog(ctx, log, notifier, heartbeats, store)
+
+	if config.API.Enabled {
+		handler := NewStatusHandler(recorder)
+		handler.Handle("/metrics", metrics.Registry)
+
+		if err := StartStatusServer(ctx, log, config.API, handler); err != nil {
+			log.Errorf("Could not start status api @ %v: %v", config.API.Address, err)
+		}
+	}
+
+	var eventSocket *EventSocketServer
 
-	// Defer closing of the channels
-	defer monitorChannel.CloseChannels()
+	if config.Events.Enabled {
+		server, err := StartEventSocketServer(ctx, log, config.Events)
 
-	// Configure all our watches
-	MonitorRimoteConnectionStatus(ctx, log, monitorChannel.RimoteMessageChannel)
-	NewEthernetMonitor(ctx, monitorChannel.EthernetMessageChannel)
-	WatchModem(ctx, log, monitorChannel.ModemStatusMessageChannel)
-	HandleHostInfo(ctx, log, monitorChannel.InfoMessageChannel)
+		if err != nil {
+			log.Errorf("Could not start event socket @ %v: %v", config.Events.Path, err)
+		}
+
+		eventSocket = server
+	}
+
+	// The publisher idles until mqtt is enabled, a reload can enable it
+	mqttPublisher := NewMQTTPublisher(log, store)
+	go mqttPublisher.Run(ctx)
+
+	// Start all our watches
+	supervisor.Start(ctx)
 
 	// Run our message loop blocking ...
-	messageloop(ctx, log, monitorChannel)
+	messageloop(ctx, log, store, &daemonServices{recorder: recorder, notifier: notifier, heartbeats: heartbeats, observer: supervisor, eventSocket: eventSocket, mqtt: mqttPublisher}, supervisor.Events())
+
+	if err := notifier.Stopping(); err != nil {
+		log.Warningf("Could not notify systemd: %v", err)
+	}
+
+	log.Info("Monitor is shutting down ...")
 
-	log.Info("Monitor is going to shutdown in 10 seconds ...")
-	time.Sleep(10 * time.Second)
+	// Wait for the monitors so the modem port is closed before we exit
+	if !supervisor.Wait(monitorShutdownTimeout) {
+		log.Warningf("Not every monitor stopped within %v", monitorShutdownTimeout)
+	}
+
+	// Give the broker our offline state
+	select {
+	case <-mqttPublisher.Done():
+	case <-time.After(mqttShutdownTimeout):
+		log.Warningf("Could not disconnect from the mqtt broker within %v", mqttShutdownTimeout)
+	}
+
+	executeWithLogger(log, "led:all", ShutdownLeds)
+
+	log.Info("Monitor stopped")
+}
+
+// monitorShutdownTimeout is the time the monitors get to stop, it must be shorter than the emergency exit
+const monitorShutdownTimeout = 5 * time.Second
+
+// mqttShutdownTimeout is the time the mqtt publisher gets to disconnect after the monitors stopped
+const mqttShutdownTimeout = 2 * time.Second
+
+// daemonServices bundles the services observing the message loop
+type daemonServices struct {
+	recorder    *StatusRecorder
+	notifier    *SystemdNotifier
+	heartbeats  *Heartbeats
+	observer    EventObserver
+	eventSocket *EventSocketServer
+	mqtt        *MQTTPublisher
 }
 
 func executeWithLogger(logger *Logger, context string, fn func() error) {
 	err := fn()
 	if err != nil {
+		metrics.LedWriteErrors.Inc(strings.TrimPrefix(context, "led:"))
 		logger.WarningF("%v %v", context, err)
 	}
 }
@@ -67,16 +132,33 @@ func initDefaults(msg *Message) {
 	msg.HardwareStatus().SetNandStatus(true)
 }
 
-func messageloop(ctx context.Context, logger *Logger, monitorChannel MonitorChannel) {
+func messageloop(ctx context.Context, logger *Logger, store *ConfigurationStore, services *daemonServices, events <-chan MonitorEvent) {
+
+	recorder := services.recorder
+	heartbeats := services.heartbeats
+	ready := false
+	summary := ""
+
+	changed := store.Changed()
+	config := store.Current()
 
-	timeout := 2000 * time.Millisecond
 	msg := NewMessage()
+	stateFileFailing := false
+	stalled := make(map[string]bool)
+	servicesOk := true
+
+	distributor, err := NewStatusDistributor(config.Status)
+
+	if err != nil {
+		logger.Errorf("Invalid status configuration: %v", err)
+		return
+	}
 
 	// Set some defaults
 	initDefaults(msg)
 
 	// Try to detect the firmware version at startup.
-	firmwareVersion, err := GetFirmwareVersion()
+	firmwareVersion, err := GetFirmwareVersion(config.HostInfo.FirmwareFile)
 
 	if err != nil {
 		logger.WarningF("Cannot detect firmware version")
@@ -86,58 +168,218 @@ func messageloop(ctx context.Context, logger *Logger, monitorChannel MonitorChan
 		logger.DebugF("Got the following firmware version information: %v", firmwareVersion)
 	}
 
+	// The heartbeat is sent when nothing changed within the interval
+	heartbeat := time.NewTimer(config.Status.Interval)
+	defer heartbeat.Stop()
+
+	lastSent := msg.Data
+
+	publish := func() {
+
+		// Failures are logged per target
+		distributor.Send(logger, msg)
+		recorder.RecordMessage(msg)
+		recorder.RecordReceivers(distributor.Receivers())
+		heartbeats.Beat("messageloop")
+
+		lastSent = msg.Data
+		resetTimer(heartbeat, config.Status.Interval)
+
+		if services.eventSocket != nil {
+			services.eventSocket.Publish(msg)
+		}
+
+		if services.mqtt != nil {
+			services.mqtt.Publish(msg, recorder.Snapshot())
+		}
+
+		if config.Status.StateFile != "" {
+			err := WriteStatusFile(config.Status.StateFile, msg.Data)
+
+			// Only warn on the first failure to prevent flooding the log
+			if err != nil && !stateFileFailing {
+				logger.Warningf("could not write status file: %v", err)
+			}
+
+			stateFileFailing = err != nil
+		}
+
+		// Tell systemd we're ready after our first status message
+		if !ready {
+			if err := services.notifier.Ready(); err != nil {
+				logger.Warningf("Could not notify systemd: %v", err)
+			}
+			ready = true
+		}
+
+		if newSummary := StatusSummary(msg); newSummary != summary {
+			if err := services.notifier.Status(newSummary); err != nil {
+				logger.Warningf("Could not notify systemd: %v", err)
+			}
+			summary = newSummary
+		}
+	}
+
 	for {
 
 		select {
 
 		case <-ctx.Done():
+			// Report we're no longer running
+			msg.GeneralStatus().SetSoftwareStatus(false)
+
+			if err := distributor.Send(logger, msg); err != nil {
+				logger.Errorf("could not send final status message: %v", err)
+			}
+
+			recorder.RecordMessage(msg)
+			distributor.Close()
+
+			if config.Status.StateFile != "" {
+				WriteStatusFile(config.Status.StateFile, msg.Data)
+			}
+
 			return
-		case rimoteMessage := <-monitorChannel.RimoteMessageChannel:
-			msg.RimoteStatus().SetRimoteGUIDPresent(rimoteMessage.HasHardwareID)
-			msg.RimoteStatus().SetRimoteConnected(rimoteMessage.IsConnected)
-			// todo: Fix this in the feature
-			msg.RimoteStatus().SetRimoteSSLOk(true)
-			msg.RimoteStatus().SetRimoteConfOk(true)
-
-			executeWithLogger(logger, "led:rimote", func() error {
-				return SetRimoteLed(rimoteMessage.IsConnected)
-			})
-		case ethernetMessage := <-monitorChannel.EthernetMessageChannel:
-			msg.ConnectionStatus().SetEth0Status(ethernetMessage.Eth0.Connected)
-			msg.ConnectionStatus().SetEth1Status(ethernetMessage.Eth1.Connected)
-			msg.ConnectionStatus().SetEthernetConfigurationStatus(ethernetMessage.EthernetConfigured)
-			msg.ConnectionStatus().SetWifiEnabled(ethernetMessage.Wifi0.Connected)
-			if ethernetMessage.Wifi0.Connected {
-				msg.ConnectionStatus().SetWifiSignal(FairSignal)
-			} else {
-				msg.ConnectionStatus().SetWifiSignal(NoSignal)
+		case <-changed:
+			changed = store.Changed()
+			config = store.Current()
+			resetTimer(heartbeat, config.Status.Interval)
+
+			ConfigureLeds(config.Leds)
+
+			if err := distributor.Reconfigure(config.Status); err != nil {
+				logger.Errorf("Invalid status configuration keeping: %v error: %v", distributor.Targets(), err)
 			}
-			setConnectionLeds(logger, ethernetMessage)
-		case modemMessage := <-monitorChannel.ModemStatusMessageChannel:
-			msg.ConnectionStatus().SetMobileInternetEnabled(modemMessage.ModemAvailable)
-			msg.ConnectionStatus().SetSimPinOK(modemMessage.SimpinOk)
-			msg.ConnectionStatus().SetModemSignal(modemMessage.SignalStrength)
-			msg.ConnectionStatus().SetBroadbandConnectionType(modemMessage.BroadbandConnType)
-
-			setModemLed(logger, modemMessage)
-
-			// Report status back
-			monitorChannel.InfoMessageChannel <- HostInfo{
-				ModemEnabled: modemMessage.ModemAvailable,
-				SimID:        modemMessage.SimUccid,
+		case event := <-events:
+			switch event.Payload.(type) {
+			case MonitorStalled, MonitorCrashed:
+				stalled[event.Source] = true
+				clearMonitorStatus(msg, event.Source)
+
+				// The stalled monitor fails the software bit until the services are reported again
+				if event.Source == "services" {
+					servicesOk = true
+				}
+			default:
+				heartbeats.Beat(event.Source)
+				delete(stalled, event.Source)
+				handleMonitorEvent(logger, recorder, msg, event)
+				services.observer.Observe(event)
+
+				if servicesMessage, ok := event.Payload.(ServicesMessage); ok {
+					servicesOk = servicesMessage.Ok
+				}
 			}
 
-		default:
-			time.Sleep(timeout)
-			err := SendMessage(logger, msg.Data)
+			// The software is only healthy when every monitor makes progress and every service runs
+			msg.GeneralStatus().SetSoftwareStatus(len(stalled) == 0 && servicesOk)
 
-			if err != nil {
-				logger.Errorf("could not send status message: %v", err)
+			// Send changes directly
+			if msg.Data != lastSent {
+				publish()
 			}
+		case <-heartbeat.C:
+			publish()
 		}
 	}
 }
 
+// resetTimer stops the timer, drains a pending expiry and restarts it with the duration
+func resetTimer(timer *time.Timer, duration time.Duration) {
+
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+
+	timer.Reset(duration)
+}
+
+// handleMonitorEvent applies a monitor event to the status message and the leds
+func handleMonitorEvent(logger *Logger, recorder *StatusRecorder, msg *Message, event MonitorEvent) {
+
+	switch payload := event.Payload.(type) {
+
+	case RimoteMessage:
+		rimoteMessage := payload
+		recorder.RecordRimote(rimoteMessage)
+		metrics.ObserveRimote(rimoteMessage)
+		msg.RimoteStatus().SetRimoteGUIDPresent(rimoteMessage.HasHardwareID)
+		msg.RimoteStatus().SetRimoteConnected(rimoteMessage.IsConnected)
+		// todo: Fix this in the feature
+		msg.RimoteStatus().SetRimoteSSLOk(true)
+		msg.RimoteStatus().SetRimoteConfOk(true)
+
+		executeWithLogger(logger, "led:rimote", func() error {
+			return SetRimoteLed(rimoteMessage.IsConnected)
+		})
+	case EthernetMessage:
+		ethernetMessage := payload
+		recorder.RecordEthernet(ethernetMessage)
+		metrics.ObserveEthernet(ethernetMessage)
+		msg.ConnectionStatus().SetEth0Status(ethernetMessage.Eth0.Connected)
+		msg.ConnectionStatus().SetEth1Status(ethernetMessage.Eth1.Connected)
+		msg.ConnectionStatus().SetEthernetConfigurationStatus(ethernetMessage.EthernetConfigured)
+		msg.ConnectionStatus().SetWifiEnabled(ethernetMessage.Wifi0.Connected)
+		if ethernetMessage.Wifi0.Connected {
+			msg.ConnectionStatus().SetWifiSignal(FairSignal)
+		} else {
+			msg.ConnectionStatus().SetWifiSignal(NoSignal)
+		}
+		setConnectionLeds(logger, ethernetMessage)
+	case ModemStatusMessage:
+		modemMessage := payload
+		recorder.RecordModem(modemMessage)
+		metrics.ObserveModem(modemMessage)
+		msg.ConnectionStatus().SetMobileInternetEnabled(modemMessage.ModemAvailable)
+		msg.ConnectionStatus().SetSimPinOK(modemMessage.SimpinOk)
+		msg.ConnectionStatus().SetModemSignal(modemMessage.SignalStrength)
+		msg.ConnectionStatus().SetBroadbandConnectionType(modemMessage.BroadbandConnType)
+
+		setModemLed(logger, modemMessage)
+	case VccMessage:
+		recorder.RecordVcc(payload)
+		metrics.ObserveVcc(payload)
+		msg.GeneralStatus().SetVccStatus(payload.Ok)
+	case StorageMessage:
+		recorder.RecordStorage(payload)
+		metrics.ObserveStorage(payload)
+		msg.HardwareStatus().SetNandStatus(payload.Ok)
+	case ServicesMessage:
+		// The software bit is derived in the message loop
+		recorder.RecordServices(payload)
+		metrics.ObserveServices(payload)
+	default:
+		logger.Warningf("Ignoring unknown event from monitor: %v", event.Source)
+	}
+}
+
+// clearMonitorStatus clears the status bits of a stalled or crashed monitor so we don't report stale values
+func clearMonitorStatus(msg *Message, source string) {
+
+	switch source {
+	case "rimote":
+		msg.RimoteStatus().SetRimoteConnected(false)
+	case "ethernet":
+		msg.ConnectionStatus().SetEth0Status(false)
+		msg.ConnectionStatus().SetEth1Status(false)
+		msg.ConnectionStatus().SetEthernetConfigurationStatus(false)
+		msg.ConnectionStatus().SetWifiEnabled(false)
+		msg.ConnectionStatus().SetWifiSignal(NoSignal)
+	case "modem":
+		msg.ConnectionStatus().SetMobileInternetEnabled(false)
+		msg.ConnectionStatus().SetSimPinOK(false)
+		msg.ConnectionStatus().SetModemSignal(NoSignal)
+		msg.ConnectionStatus().SetBroadbandConnectionType(ConnTypeNoNetwork)
+	case "vcc":
+		msg.GeneralStatus().SetVccStatus(false)
+	case "storage":
+		msg.HardwareStatus().SetNandStatus(false)
+	}
+}
+
 func setModemLed(logger *Logger, modemStatusMessage ModemStatusMessage) {
 	executeWithLogger(logger, "led:broadband", func() error {
 
@@ -145,6 +387,14 @@ func setModemLed(logger *Logger, modemStatusMessage ModemStatusMessage) {
 			return SetBroadbandLed(ErrorSignal)
 		}
 
+		// The signal quality doesn't matter when the network rejects the sim or there's no network yet
+		switch modemStatusMessage.Registration {
+		case RegistrationDenied:
+			return SetBroadbandLed(ErrorSignal)
+		case RegistrationSearching, RegistrationNotRegistered:
+			return SetBroadbandLed(NoSignal)
+		}
+
 		if modemStatusMessage.DataAvailable {
 			return SetBroadbandLed(GoodSignal)
 		}
@@ -1 +1,65 @@
 package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type discardObserver struct{}
+
+func (discardObserver) Observe(event MonitorEvent) {}
+
+func TestMessageloopSendsChangesDirectly(t *testing.T) {
+
+	receiver := listenStatusTarget(t)
+	defer receiver.Close()
+
+	config := DefaultConfiguration()
+	config.Status.Address = receiver.LocalAddr().String()
+	config.Status.StateFile = ""
+	config.Status.Interval = time.Hour
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	services := &daemonServices{
+		recorder:   NewStatusRecorder(),
+		notifier:   &SystemdNotifier{},
+		heartbeats: NewHeartbeats(),
+		observer:   discardObserver{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan MonitorEvent)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		messageloop(ctx, logger, NewConfigurationStore("", config), services, events)
+	}()
+
+	// A stalled monitor clears the software bit which must be sent without waiting for the heartbeat
+	events <- MonitorEvent{Source: "rimote", Time: time.Now(), Payload: MonitorStalled{}}
+
+	msg := &Message{}
+	copy(msg.Data[:], readStatusDatagram(t, receiver))
+
+	if msg.GeneralStatus().GetSoftwareStatus() {
+		t.Errorf("Expected the software status to be cleared got: %x", msg.Data)
+	}
+
+	cancel()
+	<-stopped
+
+	// The final message is sent on shutdown
+	copy(msg.Data[:], readStatusDatagram(t, receiver))
+
+	if msg.GeneralStatus().GetSoftwareStatus() {
+		t.Errorf("Expected the final message without software status got: %x", msg.Data)
+	}
+}
@@ -215,6 +215,27 @@ func (connectionStatus *ConnectionStatus) SetModemSignal(signalStrength SignalSt
 	}
 }
 
+// GetModemSignal returns the modem signal
+func (connectionStatus *ConnectionStatus) GetModemSignal() SignalStrength {
+
+	bit0 := getBit(connectionStatus.State2, 0)
+	bit1 := getBit(connectionStatus.State2, 1)
+	bit2 := getBit(connectionStatus.State2, 2)
+
+	switch {
+	case bit0 && bit1 && bit2:
+		return NoSignal
+	case bit0 && !bit1 && !bit2:
+		return WeakSignal
+	case !bit0 && bit1 && !bit2:
+		return FairSignal
+	case bit0 && bit1 && !bit2:
+		return GoodSignal
+	default:
+		return ErrorSignal
+	}
+}
+
 // SetWifiSignal sets the wifi signal
 func (connectionStatus *ConnectionStatus) SetWifiSignal(signalStrength SignalStrength) {
 
@@ -243,9 +264,34 @@ func (connectionStatus *ConnectionStatus) SetWifiSignal(signalStrength SignalStr
 	}
 }
 
-// SetBroadbandConnectionType sets the wifi signal
+// GetWifiSignal returns the wifi signal
+func (connectionStatus *ConnectionStatus) GetWifiSignal() SignalStrength {
+
+	bit1 := getBit(connectionStatus.State1, 1)
+	bit2 := getBit(connectionStatus.State1, 2)
+	bit3 := getBit(connectionStatus.State1, 3)
+
+	switch {
+	case bit1 && bit2 && bit3:
+		return NoSignal
+	case bit1 && !bit2 && !bit3:
+		return WeakSignal
+	case !bit1 && bit2 && !bit3:
+		return FairSignal
+	case !bit1 && !bit2 && bit3:
+		return GoodSignal
+	default:
+		return ErrorSignal
+	}
+}
+
+// SetBroadbandConnectionType sets the broadband connection type, 4G and 5G set an extra bit on top of the 3G bits
+// so receivers which don't know them report 3G
 func (connectionStatus *ConnectionStatus) SetBroadbandConnectionType(broadbandConnType BroadbandConnType) {
 
+	setBit(connectionStatus.State2, 3, broadbandConnType == ConnType4G || broadbandConnType == ConnType5G)
+	setBit(connectionStatus.State2, 4, broadbandConnType == ConnType5G)
+
 	if broadbandConnType == ConnTypeNoNetwork {
 		setBit(connectionStatus.State1, 6, false)
 		setBit(connectionStatus.State1, 7, false)
@@ -256,12 +302,32 @@ func (connectionStatus *ConnectionStatus) SetBroadbandConnectionType(broadbandCo
 		setBit(connectionStatus.State1, 7, false)
 	}
 
-	if broadbandConnType == ConnType3G || broadbandConnType == ConnType4G {
+	if broadbandConnType == ConnType3G || broadbandConnType == ConnType4G || broadbandConnType == ConnType5G {
 		setBit(connectionStatus.State1, 6, true)
 		setBit(connectionStatus.State1, 7, true)
 	}
 }
 
+// GetBroadbandConnectionType returns the broadband connection type
+func (connectionStatus *ConnectionStatus) GetBroadbandConnectionType() BroadbandConnType {
+
+	bit6 := getBit(connectionStatus.State1, 6)
+	bit7 := getBit(connectionStatus.State1, 7)
+
+	switch {
+	case bit6 && bit7 && getBit(connectionStatus.State2, 4):
+		return ConnType5G
+	case bit6 && bit7 && getBit(connectionStatus.State2, 3):
+		return ConnType4G
+	case bit6 && bit7:
+		return ConnType3G
+	case bit6:
+		return ConnType2G
+	default:
+		return ConnTypeNoNetwork
+	}
+}
+
 func setBit(b *byte, bit uint, value bool) {
 	x := *b
 	if value {
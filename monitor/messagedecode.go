@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DecodedMessage structure containing every field of the status message
+type DecodedMessage struct {
+	General    DecodedGeneralStatus    `json:"general"`
+	Hardware   DecodedHardwareStatus   `json:"hardware"`
+	Connection DecodedConnectionStatus `json:"connection"`
+	Rimote     DecodedRimoteStatus     `json:"rimote"`
+}
+
+// DecodedGeneralStatus structure
+type DecodedGeneralStatus struct {
+	Hardware bool `json:"hardware"`
+	Software bool `json:"software"`
+	Vcc      bool `json:"vcc"`
+}
+
+// DecodedHardwareStatus structure
+type DecodedHardwareStatus struct {
+	Nand bool `json:"nand"`
+}
+
+// DecodedConnectionStatus structure
+type DecodedConnectionStatus struct {
+	WifiEnabled           bool              `json:"wifiEnabled"`
+	WifiSignal            SignalStrength    `json:"wifiSignal"`
+	MobileInternetEnabled bool              `json:"mobileInternetEnabled"`
+	SimPinOk              bool              `json:"simPinOk"`
+	BroadbandConnType     BroadbandConnType `json:"broadbandConnType"`
+	ModemSignal           SignalStrength    `json:"modemSignal"`
+	EthernetConfigured    bool              `json:"ethernetConfigured"`
+	Eth0                  bool              `json:"eth0"`
+	Eth1                  bool              `json:"eth1"`
+}
+
+// DecodedRimoteStatus structure
+type DecodedRimoteStatus struct {
+	Connected   bool `json:"connected"`
+	GUIDPresent bool `json:"guidPresent"`
+	SSLOk       bool `json:"sslOk"`
+	ConfOk      bool `json:"confOk"`
+}
+
+// Decode decodes the status message into its separate fields
+func (message *Message) Decode() DecodedMessage {
+
+	general := message.GeneralStatus()
+	hardware := message.HardwareStatus()
+	connection := message.ConnectionStatus()
+	rimote := message.RimoteStatus()
+
+	return DecodedMessage{
+		General: DecodedGeneralStatus{
+			Hardware: general.GetHardwareStatus(),
+			Software: general.GetSoftwareStatus(),
+			Vcc:      general.GetVccStatus(),
+		},
+		Hardware: DecodedHardwareStatus{
+			Nand: hardware.GetNandStatus(),
+		},
+		Connection: DecodedConnectionStatus{
+			WifiEnabled:           connection.GetWifiEnabled(),
+			WifiSignal:            connection.GetWifiSignal(),
+			MobileInternetEnabled: connection.GetMobileInternetEnabled(),
+			SimPinOk:              connection.GetSimpinOk(),
+			BroadbandConnType:     connection.GetBroadbandConnectionType(),
+			ModemSignal:           connection.GetModemSignal(),
+			EthernetConfigured:    connection.GetEthernetConfigurationStatus(),
+			Eth0:                  connection.GetEth0Status(),
+			Eth1:                  connection.GetEth1Status(),
+		},
+		Rimote: DecodedRimoteStatus{
+			Connected:   rimote.GetRimoteConnected(),
+			GUIDPresent: rimote.GetRimoteGUIDPresent(),
+			SSLOk:       rimote.GetRimoteSSLStatus(),
+			ConfOk:      rimote.GetRimoteConfOk(),
+		},
+	}
+}
+
+// Fields returns the decoded message as ordered name/value pairs
+func (decoded DecodedMessage) Fields() [][2]string {
+
+	return [][2]string{
+		{"general.hardware", fmt.Sprint(decoded.General.Hardware)},
+		{"general.software", fmt.Sprint(decoded.General.Software)},
+		{"general.vcc", fmt.Sprint(decoded.General.Vcc)},
+		{"hardware.nand", fmt.Sprint(decoded.Hardware.Nand)},
+		{"connection.wifi-enabled", fmt.Sprint(decoded.Connection.WifiEnabled)},
+		{"connection.wifi-signal", decoded.Connection.WifiSignal.String()},
+		{"connection.mobile-internet", fmt.Sprint(decoded.Connection.MobileInternetEnabled)},
+		{"connection.simpin-ok", fmt.Sprint(decoded.Connection.SimPinOk)},
+		{"connection.broadband-type", decoded.Connection.BroadbandConnType.String()},
+		{"connection.modem-signal", decoded.Connection.ModemSignal.String()},
+		{"connection.ethernet-configured", fmt.Sprint(decoded.Connection.EthernetConfigured)},
+		{"connection.eth0", fmt.Sprint(decoded.Connection.Eth0)},
+		{"connection.eth1", fmt.Sprint(decoded.Connection.Eth1)},
+		{"rimote.connected", fmt.Sprint(decoded.Rimote.Connected)},
+		{"rimote.guid-present", fmt.Sprint(decoded.Rimote.GUIDPresent)},
+		{"rimote.ssl-ok", fmt.Sprint(decoded.Rimote.SSLOk)},
+		{"rimote.conf-ok", fmt.Sprint(decoded.Rimote.ConfOk)},
+	}
+}
+
+// WriteDecodedMessage writes a human readable representation of the status message
+func WriteDecodedMessage(w io.Writer, message *Message) {
+
+	fmt.Fprintf(w, "raw: % x\n", message.Data[:])
+
+	for _, field := range message.Decode().Fields() {
+		fmt.Fprintf(w, "%-32v %v\n", field[0]+":", field[1])
+	}
+}
+
+// WriteDecodedChanges writes the status message like WriteDecodedMessage and marks the fields changed since the previous message
+func WriteDecodedChanges(w io.Writer, previous *Message, message *Message) {
+
+	fmt.Fprintf(w, "raw: % x\n", message.Data[:])
+
+	var previousFields [][2]string
+
+	if previous != nil {
+		previousFields = previous.Decode().Fields()
+	}
+
+	for i, field := range message.Decode().Fields() {
+
+		if previousFields != nil && previousFields[i][1] != field[1] {
+			fmt.Fprintf(w, "* %-30v %v (was %v)\n", field[0]+":", field[1], previousFields[i][1])
+			continue
+		}
+
+		fmt.Fprintf(w, "  %-30v %v\n", field[0]+":", field[1])
+	}
+}
+
+// ParseStatusHex parses a status frame pasted from a log, spaces, colons and a 0x prefix are ignored
+func ParseStatusHex(text string) ([]byte, error) {
+
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "raw:")
+	text = strings.TrimPrefix(strings.TrimSpace(text), "0x")
+
+	text = strings.Map(func(r rune) rune {
+		if r == ':' || r == '-' || strings.ContainsRune(" \t\r\n", r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	return hex.DecodeString(text)
+}
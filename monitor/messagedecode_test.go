@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSignalRoundTrip(t *testing.T) {
+
+	for _, strength := range []SignalStrength{NoSignal, WeakSignal, FairSignal, GoodSignal} {
+		msg := NewMessage()
+		msg.ConnectionStatus().SetModemSignal(strength)
+		msg.ConnectionStatus().SetWifiSignal(strength)
+
+		if got := msg.ConnectionStatus().GetModemSignal(); got != strength {
+			t.Errorf("Expected modem signal: %v got: %v", strength, got)
+		}
+
+		if got := msg.ConnectionStatus().GetWifiSignal(); got != strength {
+			t.Errorf("Expected wifi signal: %v got: %v", strength, got)
+		}
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+
+	msg := NewMessage()
+	initDefaults(msg)
+	msg.ConnectionStatus().SetEth1Status(true)
+	msg.ConnectionStatus().SetSimPinOK(true)
+	msg.ConnectionStatus().SetBroadbandConnectionType(ConnType2G)
+	msg.RimoteStatus().SetRimoteConnected(true)
+
+	decoded := msg.Decode()
+
+	if !decoded.General.Hardware || !decoded.General.Software || !decoded.General.Vcc || !decoded.Hardware.Nand {
+		t.Errorf("Expected all default status bits to be set got: %+v", decoded)
+	}
+
+	if decoded.Connection.Eth0 || !decoded.Connection.Eth1 {
+		t.Errorf("Expected only eth1 to be connected got: %+v", decoded.Connection)
+	}
+
+	if !decoded.Connection.SimPinOk {
+		t.Errorf("Expected sim pin ok")
+	}
+
+	if decoded.Connection.BroadbandConnType != ConnType2G {
+		t.Errorf("Expected broadband type: %v got: %v", ConnType2G, decoded.Connection.BroadbandConnType)
+	}
+
+	if !decoded.Rimote.Connected || decoded.Rimote.GUIDPresent {
+		t.Errorf("Expected only rimote connected got: %+v", decoded.Rimote)
+	}
+}
+
+func TestParseStatusHex(t *testing.T) {
+
+	expected := []byte{0x0f, 0x01, 0x00, 0xa2, 0x00, 0x00, 0x10, 0x00}
+
+	for _, text := range []string{
+		"0f0100a200001000",
+		"0x0F0100A200001000",
+		"0f 01 00 a2 00 00 10 00",
+		"raw: 0f 01 00 a2 00 00 10 00\n",
+		"0f:01:00:a2:00:00:10:00",
+	} {
+		data, err := ParseStatusHex(text)
+
+		if err != nil {
+			t.Errorf("Got unexpected error for: %q %v", text, err)
+			continue
+		}
+
+		if !bytes.Equal(data, expected) {
+			t.Errorf("Expected: %x for: %q got: %x", expected, text, data)
+		}
+	}
+
+	if _, err := ParseStatusHex("0f 01 zz"); err == nil {
+		t.Errorf("Expected an error for invalid hex")
+	}
+}
+
+func TestWriteDecodedChanges(t *testing.T) {
+
+	previous := NewMessage()
+	msg := NewMessage()
+	msg.ConnectionStatus().SetEth0Status(true)
+
+	out := &bytes.Buffer{}
+	WriteDecodedChanges(out, previous, msg)
+
+	var changed []string
+
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "*") {
+			changed = append(changed, line)
+		}
+	}
+
+	if len(changed) != 1 || !strings.Contains(changed[0], "connection.eth0:") || !strings.HasSuffix(changed[0], "true (was false)") {
+		t.Errorf("Expected only eth0 to be marked as changed got: %q", changed)
+	}
+
+	out.Reset()
+	WriteDecodedChanges(out, nil, msg)
+
+	if strings.Contains(out.String(), "*") {
+		t.Errorf("Expected no changes without a previous message got:\n%v", out.String())
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricType type
+type MetricType string
+
+const (
+	// GaugeMetric can go up and down
+	GaugeMetric MetricType = "gauge"
+	// CounterMetric only goes up
+	CounterMetric MetricType = "counter"
+)
+
+// MetricsRegistry holds metrics and writes them in the prometheus text format
+type MetricsRegistry struct {
+	mutex   sync.Mutex
+	metrics []*MetricVec
+}
+
+// MetricVec structure holding a metric with zero or more labels
+type MetricVec struct {
+	registry   *MetricsRegistry
+	name       string
+	help       string
+	metricType MetricType
+	labelNames []string
+	values     map[string]float64
+}
+
+// NewMetricsRegistry creates an empty registry
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+// NewGauge registers a new gauge
+func (registry *MetricsRegistry) NewGauge(name string, help string, labelNames ...string) *MetricVec {
+	return registry.register(name, help, GaugeMetric, labelNames)
+}
+
+// NewCounter registers a new counter
+func (registry *MetricsRegistry) NewCounter(name string, help string, labelNames ...string) *MetricVec {
+	return registry.register(name, help, CounterMetric, labelNames)
+}
+
+func (registry *MetricsRegistry) register(name string, help string, metricType MetricType, labelNames []string) *MetricVec {
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	metric := &MetricVec{
+		registry:   registry,
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+	}
+
+	registry.metrics = append(registry.metrics, metric)
+
+	return metric
+}
+
+// Set sets the value for the given label values
+func (metric *MetricVec) Set(value float64, labelValues ...string) {
+
+	key := metric.key(labelValues)
+
+	metric.registry.mutex.Lock()
+	defer metric.registry.mutex.Unlock()
+
+	metric.values[key] = value
+}
+
+// SetBool sets the value to 1 when true and 0 otherwise
+func (metric *MetricVec) SetBool(value bool, labelValues ...string) {
+
+	if value {
+		metric.Set(1, labelValues...)
+	} else {
+		metric.Set(0, labelValues...)
+	}
+}
+
+// Add adds delta to the value for the given label values
+func (metric *MetricVec) Add(delta float64, labelValues ...string) {
+
+	key := metric.key(labelValues)
+
+	metric.registry.mutex.Lock()
+	defer metric.registry.mutex.Unlock()
+
+	metric.values[key] += delta
+}
+
+// Inc increments the value for the given label values
+func (metric *MetricVec) Inc(labelValues ...string) {
+	metric.Add(1, labelValues...)
+}
+
+// Value returns the value for the given label values
+func (metric *MetricVec) Value(labelValues ...string) float64 {
+
+	key := metric.key(labelValues)
+
+	metric.registry.mutex.Lock()
+	defer metric.registry.mutex.Unlock()
+
+	return metric.values[key]
+}
+
+func (metric *MetricVec) key(labelValues []string) string {
+
+	if len(labelValues) != len(metric.labelNames) {
+		panic(fmt.Sprintf("metric %v expects %v label values got: %v", metric.name, len(metric.labelNames), len(labelValues)))
+	}
+
+	if len(labelValues) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(labelValues))
+
+	for i, value := range labelValues {
+		pairs[i] = fmt.Sprintf("%v=\"%v\"", metric.labelNames[i], escapeLabelValue(value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+// WriteTo writes all metrics in the prometheus text format
+func (registry *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+
+	buffer := &bytes.Buffer{}
+
+	registry.mutex.Lock()
+
+	for _, metric := range registry.metrics {
+
+		fmt.Fprintf(buffer, "# HELP %v %v\n", metric.name, metric.help)
+		fmt.Fprintf(buffer, "# TYPE %v %v\n", metric.name, metric.metricType)
+
+		// Unlabeled metrics always have a value
+		if len(metric.labelNames) == 0 {
+			fmt.Fprintf(buffer, "%v %v\n", metric.name, formatMetricValue(metric.values[""]))
+			continue
+		}
+
+		keys := make([]string, 0, len(metric.values))
+		for key := range metric.values {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(buffer, "%v%v %v\n", metric.name, key, formatMetricValue(metric.values[key]))
+		}
+	}
+
+	registry.mutex.Unlock()
+
+	return buffer.WriteTo(w)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// ServeHTTP serves the metrics for prometheus
+func (registry *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	registry.WriteTo(w)
+}
+
+// MonitorMetrics structure containing every metric of the monitor
+type MonitorMetrics struct {
+	Registry *MetricsRegistry
+
+	ModemAvailable    *MetricVec
+	ModemCsq          *MetricVec
+	ModemBer          *MetricVec
+	ModemSignal       *MetricVec
+	BroadbandConnType *MetricVec
+	SimPinOk          *MetricVec
+	AdapterCarrier    *MetricVec
+	AdapterConfigured *MetricVec
+	RimoteConnected   *MetricVec
+	ReceiverAlive     *MetricVec
+	SupplyVoltage     *MetricVec
+	SupplyOk          *MetricVec
+	StorageValue      *MetricVec
+	StorageOk         *MetricVec
+	ServiceOk         *MetricVec
+	ServiceRestarts   *MetricVec
+
+	ATCommandErrors  *MetricVec
+	ModemReconnects  *MetricVec
+	StatusSendErrors *MetricVec
+	StatusAcks       *MetricVec
+	LedWriteErrors   *MetricVec
+	MonitorRestarts  *MetricVec
+	MonitorCrashes   *MetricVec
+
+	EventClients        *MetricVec
+	EventClientsDropped *MetricVec
+
+	MQTTConnected     *MetricVec
+	MQTTPublishErrors *MetricVec
+	MQTTDropped       *MetricVec
+}
+
+// NewMonitorMetrics creates and registers the metrics of the monitor
+func NewMonitorMetrics() *MonitorMetrics {
+
+	registry := NewMetricsRegistry()
+
+	return &MonitorMetrics{
+		Registry: registry,
+
+		ModemAvailable:    registry.NewGauge("rm_monitor_modem_available", "Whether the modem responds to AT commands."),
+		ModemCsq:          registry.NewGauge("rm_monitor_modem_csq", "Raw AT+CSQ signal quality (0-31, 99 is unknown)."),
+		ModemBer:          registry.NewGauge("rm_monitor_modem_ber", "Raw AT+CSQ bit error rate (0-7, 99 is unknown)."),
+		ModemSignal:       registry.NewGauge("rm_monitor_modem_signal_strength", "Modem signal strength (0 error, 1 none, 2 weak, 3 fair, 4 good)."),
+		BroadbandConnType: registry.NewGauge("rm_monitor_broadband_connection_type", "Broadband connection type (0 none, 1 2G, 2 3G, 3 4G, 4 5G)."),
+		SimPinOk:          registry.NewGauge("rm_monitor_sim_pin_ok", "Whether the sim card is ready and not pin locked."),
+		AdapterCarrier:    registry.NewGauge("rm_monitor_adapter_carrier", "Whether the network adapter is up with a carrier.", "adapter"),
+		AdapterConfigured: registry.NewGauge("rm_monitor_adapter_configured", "Whether the network adapter exists.", "adapter"),
+		RimoteConnected:   registry.NewGauge("rm_monitor_rimote_connected", "Whether the rimote service reports a connection."),
+		SupplyVoltage:     registry.NewGauge("rm_monitor_supply_voltage", "Measured supply voltage in volts per channel.", "channel"),
+		SupplyOk:          registry.NewGauge("rm_monitor_supply_ok", "Whether the supply voltage is within range per channel.", "channel"),
+		StorageValue:      registry.NewGauge("rm_monitor_storage_value", "Value of a storage health check (bad blocks, wear, free percent) per check.", "check"),
+		StorageOk:         registry.NewGauge("rm_monitor_storage_ok", "Whether a storage health check passed per check.", "check"),
+		ServiceOk:         registry.NewGauge("rm_monitor_service_ok", "Whether the service runs and is not crash looping per service.", "service"),
+		ServiceRestarts:   registry.NewGauge("rm_monitor_service_restarts", "Restarts of the service within the restart window per service.", "service"),
+		ReceiverAlive:     registry.NewGauge("rm_monitor_status_receiver_alive", "Whether the status receiver acknowledged within the feedback timeout per target.", "target"),
+
+		ATCommandErrors:  registry.NewCounter("rm_monitor_at_command_errors_total", "AT command errors per command.", "command"),
+		ModemReconnects:  registry.NewCounter("rm_monitor_modem_reconnects_total", "Times the modem port was reopened."),
+		StatusSendErrors: registry.NewCounter("rm_monitor_status_send_failures_total", "Status datagrams which could not be sent per target.", "target"),
+		StatusAcks:       registry.NewCounter("rm_monitor_status_acks_total", "Acknowledgements received from status receivers per target.", "target"),
+		LedWriteErrors:   registry.NewCounter("rm_monitor_led_write_failures_total", "Failed led writes per led.", "led"),
+		MonitorRestarts:  registry.NewCounter("rm_monitor_monitor_restarts_total", "Restarts of stalled monitors per monitor.", "monitor"),
+		MonitorCrashes:   registry.NewCounter("rm_monitor_monitor_crashes_total", "Recovered panics per monitor.", "monitor"),
+
+		EventClients:        registry.NewGauge("rm_monitor_event_clients", "Clients connected to the event socket."),
+		EventClientsDropped: registry.NewCounter("rm_monitor_event_clients_dropped_total", "Event socket clients dropped because they could not keep up."),
+
+		MQTTConnected:     registry.NewGauge("rm_monitor_mqtt_connected", "Whether the mqtt publisher is connected to the broker."),
+		MQTTPublishErrors: registry.NewCounter("rm_monitor_mqtt_publish_failures_total", "Mqtt connections lost while publishing."),
+		MQTTDropped:       registry.NewCounter("rm_monitor_mqtt_dropped_total", "Mqtt events dropped because the broker could not keep up."),
+	}
+}
+
+// ObserveModem updates the modem gauges
+func (monitorMetrics *MonitorMetrics) ObserveModem(modemStatusMessage ModemStatusMessage) {
+	monitorMetrics.ModemAvailable.SetBool(modemStatusMessage.ModemAvailable)
+	monitorMetrics.ModemCsq.Set(float64(modemStatusMessage.Csq))
+	monitorMetrics.ModemBer.Set(float64(modemStatusMessage.Ber))
+	monitorMetrics.ModemSignal.Set(float64(modemStatusMessage.SignalStrength))
+	monitorMetrics.BroadbandConnType.Set(float64(modemStatusMessage.BroadbandConnType))
+	monitorMetrics.SimPinOk.SetBool(modemStatusMessage.SimpinOk)
+}
+
+// ObserveVcc updates the supply gauges
+func (monitorMetrics *MonitorMetrics) ObserveVcc(vccMessage VccMessage) {
+
+	for _, channel := range vccMessage.Channels {
+		monitorMetrics.SupplyVoltage.Set(channel.Voltage, channel.Name)
+		monitorMetrics.SupplyOk.SetBool(channel.State == VccOk, channel.Name)
+	}
+}
+
+// ObserveStorage updates the storage gauges
+func (monitorMetrics *MonitorMetrics) ObserveStorage(storageMessage StorageMessage) {
+
+	for _, check := range storageMessage.Checks {
+		monitorMetrics.StorageValue.Set(check.Value, check.Name)
+		monitorMetrics.StorageOk.SetBool(check.Ok, check.Name)
+	}
+}
+
+// ObserveServices updates the service gauges
+func (monitorMetrics *MonitorMetrics) ObserveServices(servicesMessage ServicesMessage) {
+
+	for _, service := range servicesMessage.Services {
+		monitorMetrics.ServiceOk.SetBool(service.Ok, service.Name)
+		monitorMetrics.ServiceRestarts.Set(float64(service.Restarts), service.Name)
+	}
+}
+
+// ObserveEthernet updates the adapter gauges
+func (monitorMetrics *MonitorMetrics) ObserveEthernet(ethernetMessage EthernetMessage) {
+
+	for _, adapter := range []Adapter{ethernetMessage.Eth0, ethernetMessage.Eth1, ethernetMessage.Wifi0, ethernetMessage.Ppp0} {
+		monitorMetrics.AdapterCarrier.SetBool(adapter.Connected, adapter.Name)
+		monitorMetrics.AdapterConfigured.SetBool(adapter.Configured, adapter.Name)
+	}
+}
+
+// ObserveRimote updates the rimote gauges
+func (monitorMetrics *MonitorMetrics) ObserveRimote(rimoteMessage RimoteMessage) {
+	monitorMetrics.RimoteConnected.SetBool(rimoteMessage.IsConnected)
+}
+
+// metrics are global because errors are counted deep inside the monitors
+var metrics = NewMonitorMetrics()
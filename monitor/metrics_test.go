@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetricsRegistryTextFormat(t *testing.T) {
+
+	registry := NewMetricsRegistry()
+	gauge := registry.NewGauge("test_gauge", "A test gauge.")
+	counter := registry.NewCounter("test_errors_total", "A test counter.", "command")
+
+	gauge.Set(2.5)
+	counter.Inc("AT+CSQ")
+	counter.Inc("AT+CSQ")
+	counter.Inc("AT\"X")
+
+	buffer := &bytes.Buffer{}
+	registry.WriteTo(buffer)
+
+	expected := `# HELP test_gauge A test gauge.
+# TYPE test_gauge gauge
+test_gauge 2.5
+# HELP test_errors_total A test counter.
+# TYPE test_errors_total counter
+test_errors_total{command="AT+CSQ"} 2
+test_errors_total{command="AT\"X"} 1
+`
+
+	if buffer.String() != expected {
+		t.Errorf("Unexpected metrics output:\n%v\nexpected:\n%v", buffer.String(), expected)
+	}
+}
+
+func TestObserveEthernet(t *testing.T) {
+
+	monitorMetrics := NewMonitorMetrics()
+	monitorMetrics.ObserveEthernet(EthernetMessage{
+		Eth0: Adapter{Name: "eth0", Configured: true, Connected: true},
+		Eth1: Adapter{Name: "eth1", Configured: true},
+	})
+
+	if monitorMetrics.AdapterCarrier.Value("eth0") != 1 {
+		t.Errorf("Expected eth0 carrier to be 1")
+	}
+
+	if monitorMetrics.AdapterCarrier.Value("eth1") != 0 || monitorMetrics.AdapterConfigured.Value("eth1") != 1 {
+		t.Errorf("Expected eth1 to be configured without carrier")
+	}
+}
@@ -7,11 +7,6 @@ import (
 	"time"
 )
 
-const deviceModemPort = "/dev/ttyUSB3"
-const debugModemPort = "COM10"
-
-const modemConfigFile = "/etc/wvdial.conf"
-
 // ModemStatusMessage structure
 type ModemStatusMessage struct {
 	ConfigAvailable   bool
@@ -22,6 +17,60 @@ type ModemStatusMessage struct {
 	SimCardAvailable  bool
 	SignalStrength    SignalStrength
 	BroadbandConnType BroadbandConnType
+	AccessTechnology  string
+	Registration      RegistrationState
+	Operator          string
+	MCC               string
+	MNC               string
+	Csq               int
+	Ber               int
+}
+
+func init() {
+	RegisterMonitor("modem", func(dependencies MonitorDependencies) Monitor {
+		return &modemMonitor{logger: dependencies.Logger, store: dependencies.Store, events: make(chan MonitorEvent)}
+	})
+}
+
+// modemMonitor publishes the ModemStatusMessage of WatchModem as events
+type modemMonitor struct {
+	logger *Logger
+	store  *ConfigurationStore
+	events chan MonitorEvent
+}
+
+// Name of the monitor
+func (monitor *modemMonitor) Name() string {
+	return "modem"
+}
+
+// Events returns the ModemStatusMessage events
+func (monitor *modemMonitor) Events() <-chan MonitorEvent {
+	return monitor.events
+}
+
+// Run the monitor until the context is cancelled and the modem is closed
+func (monitor *modemMonitor) Run(ctx context.Context) {
+
+	modemStatusMessageChannel := make(chan ModemStatusMessage)
+	stopped := make(chan struct{})
+
+	defer close(stopped)
+
+	// Keep draining the messages until the watcher stopped, so it never blocks while shutting down
+	go func() {
+		for {
+			select {
+			case <-stopped:
+				return
+			case modemStatusMessage := <-modemStatusMessageChannel:
+				publishEvent(ctx, monitor.events, monitor.Name(), modemStatusMessage)
+			}
+		}
+	}()
+
+	// Watch in our own goroutine so a panic reaches the supervisor
+	WatchModem(ctx, monitor.logger, monitor.store, modemStatusMessageChannel)
 }
 
 // TranslateModemDBM translates dbm, ber into a rawvalue
@@ -73,81 +122,82 @@ func TranslateModemDBM(rawValue int, berValue int) SignalStrength {
 	return NoSignal
 }
 
-// WatchModem func
-func WatchModem(ctx context.Context, logger *Logger, modemStatusMessageChannel chan ModemStatusMessage) {
+// WatchModem watches the modem until the context is cancelled
+func WatchModem(ctx context.Context, logger *Logger, store *ConfigurationStore, modemStatusMessageChannel chan ModemStatusMessage) {
 
-	timeout := 30 * time.Second
 	modemConfigAvailable := false
 
-	// Run the watcher in a new go routine.
-	go func() {
-		for {
-			select {
-			// Check if we're closed
-			case <-ctx.Done():
-				return
-			// Handle modem logic
-			default:
+	for {
+		select {
+		// Check if we're closed
+		case <-ctx.Done():
+			return
+		// Handle modem logic
+		default:
 
-				modemConfigAvailable = CheckModemConfigAvailable()
+			config := store.Current().Modem
+			timeout := config.RetryInterval
+			modemConfigAvailable = CheckModemConfigAvailable(&config)
 
-				if modemConfigAvailable {
+			if modemConfigAvailable {
 
-					// Run some checks before trying to connect
-					preFlightModemCheck(ctx, logger)
+				// Run some checks before trying to connect
+				preFlightModemCheck(ctx, logger, &config)
 
-					// Modem handling
-					err := handleModem(ctx, logger, modemStatusMessageChannel)
+				// Modem handling
+				err := handleModem(ctx, logger, store, modemStatusMessageChannel)
 
-					if err != nil {
-						logger.Errorf("Modem error: %v", err)
-						modemStatusMessageChannel <- ModemStatusMessage{ConfigAvailable: true, ModemAvailable: false}
+				// Errors during a shutdown are caused by closing the port
+				if ctx.Err() != nil {
+					return
+				}
 
-						if IsDebugMode() {
-							logger.Debugf("Waiting: %v before retrying to connect", timeout)
-						}
-						// Sleep to prevent a mad reconnect loop.
-						time.Sleep(timeout)
-					} else {
-						// We have no errors so, gracefull shutdown ...
-						return
-					}
-				} else {
-					// Report we don't have a config of a modem
-					modemStatusMessageChannel <- ModemStatusMessage{ConfigAvailable: false, ModemAvailable: CheckModemAvailable()}
+				// Everything except a shutdown means we're going to reconnect
+				metrics.ModemReconnects.Inc()
 
-					// Sleep a while before retrying.
-					time.Sleep(timeout)
+				if err != nil {
+					logger.Errorf("Modem error: %v", err)
+					modemStatusMessageChannel <- ModemStatusMessage{ConfigAvailable: true, ModemAvailable: false}
+
+					if IsDebugMode() {
+						logger.Debugf("Waiting: %v before retrying to connect", timeout)
+					}
+					// Sleep to prevent a mad reconnect loop.
+					sleepWithContext(ctx, timeout)
 				}
+			} else {
+				// Report we don't have a config of a modem
+				modemStatusMessageChannel <- ModemStatusMessage{ConfigAvailable: false, ModemAvailable: CheckModemAvailable(&config)}
+
+				// Sleep a while before retrying.
+				sleepWithContext(ctx, timeout)
 			}
 		}
-	}()
+	}
 }
 
 // CheckModemAvailable check if the system has a modem device available
-func CheckModemAvailable() bool {
-
-	modemport := getModemPort()
+func CheckModemAvailable(config *ModemConfiguration) bool {
 
-	_, err := os.Stat(modemport)
+	_, err := os.Stat(config.PortName())
 	return err == nil
 }
 
 // CheckModemConfigAvailable checks if a modem config file is available
-func CheckModemConfigAvailable() bool {
+func CheckModemConfigAvailable(config *ModemConfiguration) bool {
 
 	if !IsTargetDevice() {
 		return true
 	}
 
-	if _, err := os.Stat(modemConfigFile); err == nil {
+	if _, err := os.Stat(config.ConfigFile); err == nil {
 		return true
 	}
 
 	return false
 }
 
-func preFlightModemCheck(ctx context.Context, logger *Logger) {
+func preFlightModemCheck(ctx context.Context, logger *Logger, config *ModemConfiguration) {
 
 	// Skip when running for testing
 	if !IsTargetDevice() {
@@ -157,8 +207,8 @@ func preFlightModemCheck(ctx context.Context, logger *Logger) {
 
 	// Defaults for maximum
 	// We need atleast 45 (9 attempts * 5s) seconds to prevent reporting the status to early
-	maxAttempts := 10
-	sleepDuration := 5 * time.Second
+	maxAttempts := config.PreFlightAttempts
+	sleepDuration := config.PreFlightInterval
 
 	// Try to do some upfront checks to prevent errors from connecting to early
 	for i := 0; i < maxAttempts; i++ {
@@ -168,13 +218,13 @@ func preFlightModemCheck(ctx context.Context, logger *Logger) {
 			return
 		default:
 			// Return early when we are able to stat the modem.
-			_, err := os.Stat(deviceModemPort)
+			_, err := os.Stat(config.Port)
 			if err == nil {
 				return
 			}
 
 			// Sleep a while
-			time.Sleep(sleepDuration)
+			sleepWithContext(ctx, sleepDuration)
 		}
 
 	}
@@ -182,16 +232,17 @@ func preFlightModemCheck(ctx context.Context, logger *Logger) {
 	logger.Warningf("Modem pre-flight check failed after %v attempts", maxAttempts)
 }
 
-func handleModem(ctx context.Context, logger *Logger, modemStatusMessageChannel chan ModemStatusMessage) error {
+func handleModem(ctx context.Context, logger *Logger, store *ConfigurationStore, modemStatusMessageChannel chan ModemStatusMessage) error {
 
-	// Get the modem string
-	modemport := getModemPort()
-	commandTimeout := 5 * time.Second
+	changed := store.Changed()
+	modemConfig := store.Current().Modem
+	commandTimeout := modemConfig.CommandTimeout
+	pollInterval := modemConfig.PollInterval
 
 	// Build the config
 	config := &Config{
-		Name: modemport,
-		Baud: 115200,
+		Name: modemConfig.PortName(),
+		Baud: modemConfig.Baud,
 	}
 
 	port, err := OpenPort(config)
@@ -205,8 +256,17 @@ func handleModem(ctx context.Context, logger *Logger, modemStatusMessageChannel
 		logger.Debugf("Succesfully opened modem port: %v with baudrate: %v and default timeout of: %v", config.Name, config.Baud, commandTimeout)
 	}
 
-	// Cleanup code
-	defer func() {
+	// Reconnect when our port settings are changed
+	modemCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Closing the port aborts an in-flight AT command, so close as soon as we're cancelled
+	portClosed := make(chan struct{})
+
+	go func() {
+		defer close(portClosed)
+		<-modemCtx.Done()
+
 		err := port.Close()
 		if err != nil {
 			logger.Warningf("Could not close serial port reason: %v", err)
@@ -215,7 +275,31 @@ func handleModem(ctx context.Context, logger *Logger, modemStatusMessageChannel
 		}
 	}()
 
-	initialConnected, err := handleModemData(ctx, port, commandTimeout, logger, modemStatusMessageChannel)
+	// Cleanup code
+	defer func() {
+		cancel()
+		<-portClosed
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-modemCtx.Done():
+				return
+			case <-changed:
+				changed = store.Changed()
+				newConfig := store.Current().Modem
+
+				if newConfig.PortName() != modemConfig.PortName() || newConfig.Baud != modemConfig.Baud || newConfig.CommandTimeout != modemConfig.CommandTimeout || newConfig.PollInterval != modemConfig.PollInterval {
+					logger.Infof("Modem configuration changed reconnecting to: %v", newConfig.PortName())
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	initialConnected, err := handleModemData(modemCtx, port, commandTimeout, pollInterval, logger, modemStatusMessageChannel)
 
 	// Report that we have a modem atleast.
 	if initialConnected {
@@ -225,7 +309,7 @@ func handleModem(ctx context.Context, logger *Logger, modemStatusMessageChannel
 	return err
 }
 
-func handleModemData(ctx context.Context, port *Port, commandTimeout time.Duration, logger *Logger, modemStatusMessageChannel chan ModemStatusMessage) (bool, error) {
+func handleModemData(ctx context.Context, port *Port, commandTimeout time.Duration, pollInterval time.Duration, logger *Logger, modemStatusMessageChannel chan ModemStatusMessage) (bool, error) {
 
 	for {
 		select {
@@ -234,7 +318,7 @@ func handleModemData(ctx context.Context, port *Port, commandTimeout time.Durati
 			return false, nil
 
 		case <-time.After(commandTimeout):
-			initialConnected, err := handleAT(ctx, port, commandTimeout, logger, modemStatusMessageChannel)
+			initialConnected, err := handleAT(ctx, port, commandTimeout, pollInterval, logger, modemStatusMessageChannel)
 			if err != nil {
 				return initialConnected, err
 			}
@@ -244,12 +328,3 @@ func handleModemData(ctx context.Context, port *Port, commandTimeout time.Durati
 }
 
 const atSeperator = '\r'
-
-func getModemPort() string {
-
-	if IsTargetDevice() {
-		return deviceModemPort
-	}
-
-	return debugModemPort
-}
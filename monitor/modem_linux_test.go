@@ -0,0 +1,237 @@
+// +build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitModemStatus returns the first message which matches, a poll triggered earlier may still report the old state
+func waitModemStatus(t *testing.T, messages chan ModemStatusMessage, description string, match func(ModemStatusMessage) bool) ModemStatusMessage {
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case modemStatusMessage := <-messages:
+			if match(modemStatusMessage) {
+				return modemStatusMessage
+			}
+		case <-timeout:
+			t.Fatalf("Expected a modem status message with: %v", description)
+			return ModemStatusMessage{}
+		}
+	}
+}
+
+func TestHandleATVirtualModem(t *testing.T) {
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	modem := newVirtualModem(t, virtualModemResponses())
+	defer modem.Disconnect()
+
+	// The modem starts with garbage and a few unsolicited result codes after booting
+	modem.Inject(t, "\x00\x7f", "RDY", "+CPIN: READY", "SMS DONE")
+
+	port, err := OpenPort(&Config{Name: modem.Name, Baud: 115200})
+
+	if err != nil {
+		t.Fatalf("Cannot open the virtual modem: %v", err)
+	}
+
+	defer port.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages := make(chan ModemStatusMessage)
+	result := make(chan error, 1)
+
+	go func() {
+		_, err := handleAT(ctx, port, 100*time.Millisecond, time.Hour, logger, messages)
+		result <- err
+	}()
+
+	expected := ModemStatusMessage{
+		ModemAvailable:    true,
+		DataAvailable:     true,
+		SimpinOk:          true,
+		SimUccid:          "8931087616027213997F",
+		SignalStrength:    GoodSignal,
+		BroadbandConnType: ConnType3G,
+		AccessTechnology:  "WCDMA",
+		Registration:      RegistrationHome,
+		Operator:          "KPN NL",
+		MCC:               "204",
+		MNC:               "08",
+		Csq:               18,
+		Ber:               99,
+	}
+
+	waitModemStatus(t, messages, fmt.Sprintf("%+v", expected), func(modemStatusMessage ModemStatusMessage) bool {
+		return modemStatusMessage == expected
+	})
+
+	// The sim removal is polled as soon as the modem reports it instead of after the poll interval
+	modem.Respond("AT+CPIN?", virtualResponse{Lines: []string{"+CME ERROR: SIM not inserted"}})
+	modem.Respond("AT+CSQ", virtualResponse{Lines: []string{"+CSQ: 4,99", "OK"}})
+	modem.Respond("AT+CCID", virtualOk)
+	modem.Inject(t, "+CPIN: NOT READY")
+
+	waitModemStatus(t, messages, "no sim and a weak signal", func(modemStatusMessage ModemStatusMessage) bool {
+		return !modemStatusMessage.SimpinOk && modemStatusMessage.SignalStrength == WeakSignal && modemStatusMessage.ModemAvailable &&
+			modemStatusMessage.SimUccid == ""
+	})
+
+	// The best registration of the domains is reported, the operator only when registered
+	registrations := []struct {
+		creg     string
+		cgreg    string
+		cereg    string
+		expected RegistrationState
+		operator string
+	}{
+		{creg: "+CREG: 0,5", cgreg: "+CGREG: 0,5", cereg: "ERROR", expected: RegistrationRoaming, operator: "KPN NL"},
+		{creg: "+CREG: 0,3", cgreg: "+CGREG: 0,3", cereg: "+CEREG: 0,3", expected: RegistrationDenied},
+		{creg: "+CREG: 0,0", cgreg: "+CGREG: 0,2", cereg: "+CEREG: 0,3", expected: RegistrationSearching},
+		{creg: "ERROR", cgreg: "ERROR", cereg: "ERROR", expected: RegistrationUnknown},
+		{creg: "+CREG: 0,0", cgreg: "+CGREG: 0,0", cereg: "+CEREG: 2,1,\"00C3\",\"0A2B3C4D\",7", expected: RegistrationHome, operator: "KPN NL"},
+	}
+
+	for _, tt := range registrations {
+
+		for command, line := range map[string]string{"AT+CREG?": tt.creg, "AT+CGREG?": tt.cgreg, "AT+CEREG?": tt.cereg} {
+			if line == "ERROR" {
+				modem.Respond(command, virtualResponse{Lines: []string{line}})
+			} else {
+				modem.Respond(command, virtualResponse{Lines: []string{line, "OK"}})
+			}
+		}
+
+		modem.Inject(t, "+CREG: 1")
+
+		waitModemStatus(t, messages, string(tt.expected), func(modemStatusMessage ModemStatusMessage) bool {
+			return modemStatusMessage.Registration == tt.expected && modemStatusMessage.Operator == tt.operator
+		})
+	}
+
+	// A module without AT+CNSMOD reports the access technology of the operator
+	tests := []struct {
+		cnsmod   virtualResponse
+		cops     virtualResponse
+		expected BroadbandConnType
+	}{
+		{cnsmod: virtualResponse{Lines: []string{"+CNSMOD: 0,8", "OK"}}, expected: ConnType4G},
+		{cnsmod: virtualResponse{Lines: []string{"ERROR"}}, cops: virtualResponse{Lines: []string{"+COPS: 0,0,\"KPN NL\",13", "OK"}}, expected: ConnType5G},
+		{cnsmod: virtualResponse{Lines: []string{"+CNSMOD: 0,99", "OK"}}, cops: virtualResponse{Lines: []string{"+COPS: 0,0,\"KPN NL\",9", "OK"}}, expected: ConnType4G},
+		{cnsmod: virtualResponse{Lines: []string{"ERROR"}}, cops: virtualResponse{Lines: []string{"+COPS: 0", "OK"}}, expected: ConnTypeNoNetwork},
+		// A response without the information line falls back as well instead of ending the session
+		{cnsmod: virtualOk, cops: virtualResponse{Lines: []string{"+COPS: 0,0,\"KPN NL\",7", "OK"}}, expected: ConnType4G},
+		{cnsmod: virtualOk, cops: virtualOk, expected: ConnTypeNoNetwork},
+	}
+
+	for _, tt := range tests {
+
+		modem.Respond("AT+CNSMOD?", tt.cnsmod)
+
+		// The operator of the registered modem is queried with AT+COPS? as well, an empty response would time out
+		if tt.cops.Lines != nil {
+			modem.Respond("AT+COPS?", tt.cops)
+		}
+
+		modem.Inject(t, "+CEREG: 1")
+
+		waitModemStatus(t, messages, tt.expected.String(), func(modemStatusMessage ModemStatusMessage) bool {
+			return modemStatusMessage.BroadbandConnType == tt.expected
+		})
+	}
+
+	// Removing the modem ends the session without waiting for the poll interval
+	modem.Disconnect()
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case <-messages:
+			// A poll which was already triggered
+		case err := <-result:
+			if err == nil {
+				t.Errorf("Expected an error after the disconnect")
+			}
+			return
+		case <-timeout:
+			t.Fatalf("Expected the session to end after the disconnect")
+		}
+	}
+}
+
+func TestWatchModemVirtualModem(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "rm-monitor")
+
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	modem := newVirtualModem(t, virtualModemResponses())
+	defer modem.Disconnect()
+
+	config := DefaultConfiguration()
+	config.Modem.Port = modem.Name
+	config.Modem.ConfigFile = filepath.Join(dir, "wvdial.conf")
+	config.Modem.CommandTimeout = 100 * time.Millisecond
+	config.Modem.PollInterval = time.Hour
+	config.Modem.RetryInterval = time.Hour
+
+	if err := ioutil.WriteFile(config.Modem.ConfigFile, nil, 0644); err != nil {
+		t.Fatalf("Cannot write file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := make(chan ModemStatusMessage)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		WatchModem(ctx, logger, NewConfigurationStore("", config), messages)
+	}()
+
+	waitModemStatus(t, messages, "the modem status", func(modemStatusMessage ModemStatusMessage) bool {
+		return modemStatusMessage.ModemAvailable && modemStatusMessage.Csq == 18
+	})
+
+	modem.Disconnect()
+
+	// The lost modem is reported before waiting for the retry interval
+	waitModemStatus(t, messages, "no modem", func(modemStatusMessage ModemStatusMessage) bool {
+		return modemStatusMessage.ConfigAvailable && !modemStatusMessage.ModemAvailable
+	})
+
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the watcher to stop")
+	}
+}
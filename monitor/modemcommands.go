@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -24,16 +22,34 @@ func ATCreateCommandContext(parentCtx context.Context) (ctx context.Context, can
 	return ctx, cancel
 }
 
-func handleAT(ctx context.Context, port *Port, timeout time.Duration, logger *Logger, modemStatusMessageChannel chan ModemStatusMessage) (bool, error) {
+// atRefreshPrefixes are the unsolicited result codes which trigger a poll of the modem
+var atRefreshPrefixes = []string{"+CREG:", "+CGREG:", "+CEREG:", "+CPIN:", "SMS DONE", "PB DONE"}
+
+func handleAT(ctx context.Context, port *Port, timeout time.Duration, pollInterval time.Duration, logger *Logger, modemStatusMessageChannel chan ModemStatusMessage) (bool, error) {
 
 	// Global initing for this session
-	timeoutReader := NewReader(port, timeout)
-	reader := bufio.NewReader(timeoutReader)
-	handler := &AtCommandHandler{logger: logger,
-		reader: reader,
-		writer: port}
+	handler := NewAtCommandHandler(port, timeout, logger)
+
+	// A registration or sim state change is polled directly instead of on the next interval
+	refresh := make(chan struct{}, 1)
+
+	for _, prefix := range atRefreshPrefixes {
+		handler.HandleURC(prefix, func(line string) {
+
+			if IsDebugMode() {
+				logger.Debugf("Modem reported: %v", line)
+			}
+
+			select {
+			case refresh <- struct{}{}:
+			default:
+			}
+		})
+	}
 
 	errorModeTextEnabled := false
+	accessTechnologyName := ""
+	registrationName := ""
 	initialConnected := true
 
 	for {
@@ -48,7 +64,7 @@ func handleAT(ctx context.Context, port *Port, timeout time.Duration, logger *Lo
 			simpinOk := true
 			gotSimID := true
 			signal := NoSignal
-			connType := ConnTypeNoNetwork
+			accessTechnology := AccessTechnology{}
 			csq := 0
 			ber := 0
 
@@ -97,13 +113,41 @@ func handleAT(ctx context.Context, port *Port, timeout time.Duration, logger *Lo
 			}
 
 			// Check broadband connection type
-			connType, err = ATCNSMOD(ctx, handler)
+			accessTechnology, err = ATAccessTechnology(ctx, handler)
 
 			// Check modem connection type
-			if err := TryHandleAtCommandError(logger, "AT+CNSMOD?", err, func() { connType = ConnTypeNoNetwork }); err != nil {
+			if err := TryHandleAtCommandError(logger, "AT+CNSMOD?", err, func() { accessTechnology = AccessTechnology{} }); err != nil {
 				return initialConnected, err
 			}
 
+			if accessTechnology.Name != accessTechnologyName {
+				logger.Infof("Modem access technology: %v (%v)", accessTechnology.Name, accessTechnology.ConnType)
+				accessTechnologyName = accessTechnology.Name
+			}
+
+			// Check the network registration, the operator is only known when registered
+			registration, err := ATRegistration(ctx, handler)
+
+			if err := TryHandleAtCommandError(logger, "AT+CREG?", err, func() { registration = RegistrationUnknown }); err != nil {
+				return initialConnected, err
+			}
+
+			operator := Operator{}
+
+			if registration.Registered() {
+
+				operator, err = ATOperator(ctx, handler)
+
+				if err := TryHandleAtCommandError(logger, "AT+COPS?", err, func() { operator = Operator{} }); err != nil {
+					return initialConnected, err
+				}
+			}
+
+			if name := formatRegistration(registration, operator); name != registrationName {
+				logger.Infof("Modem registration: %v", name)
+				registrationName = name
+			}
+
 			// GET SIM ID
 			str, err := ATCCID(ctx, handler)
 
@@ -119,7 +163,22 @@ func handleAT(ctx context.Context, port *Port, timeout time.Duration, logger *Lo
 				SignalStrength:    signal,
 				SimpinOk:          simpinOk,
 				SimUccid:          str,
-				BroadbandConnType: connType,
+				BroadbandConnType: accessTechnology.ConnType,
+				AccessTechnology:  accessTechnology.Name,
+				Registration:      registration,
+				Operator:          operator.Name,
+				MCC:               operator.MCC,
+				MNC:               operator.MNC,
+				Csq:               csq,
+				Ber:               ber,
+			}
+
+			// A closed port fails the next command directly
+			select {
+			case <-ctx.Done():
+			case <-refresh:
+			case <-handler.Closed():
+			case <-time.After(pollInterval):
 			}
 		}
 	}
@@ -132,453 +191,397 @@ func TryHandleAtCommandError(logger *Logger, cmd string, err error, atErrorHandl
 		return nil
 	}
 
+	metrics.ATCommandErrors.Inc(cmd)
+
 	if IsDebugMode() {
 		logger.Debugf("Error: %v in command: %v", err.Error(), cmd)
 	}
 
-	// Check if we can cast to an error from which we can recover
-	if _, ok := err.(*SimError); ok {
-
-		if ok {
-
-			// log in debug mode
-			if IsDebugMode() {
-				logger.Debugf("Ignoring error: %v in command: %v", err.Error(), cmd)
-			}
-
-			// Call the handler
-			atErrorHandler()
+	// A sim error, an error result code or a malformed response can be recovered from, a timeout or port error not
+	if isATRecoverable(err) {
 
-			return nil
+		// log in debug mode
+		if IsDebugMode() {
+			logger.Debugf("Ignoring error: %v in command: %v", err.Error(), cmd)
 		}
 
-		return err
+		// Call the handler
+		atErrorHandler()
+
+		return nil
 	}
+
 	// fallback
 	return err
 }
 
-// AtCommandHandler structure
-type AtCommandHandler struct {
-	reader *bufio.Reader
-	writer *Port
-	logger *Logger
-}
-
-// HandleCommand the serial handler
-func (atCommandHandler *AtCommandHandler) HandleCommand(parentCtx context.Context, f func(ctx context.Context, cancel context.CancelFunc) error) error {
-
-	commandCtx, cancel := ATCreateCommandContext(parentCtx)
-
-	// Defer cancellation.
-	defer cancel()
+// AT command function
+func AT(ctx context.Context, handler *AtCommandHandler) error {
 
-	return f(commandCtx, cancel)
+	_, err := handler.Command(ctx, "AT")
+	return err
 }
 
-// HandleCommandWithOutput the serial handler
-func (atCommandHandler *AtCommandHandler) HandleCommandWithOutput(parentCtx context.Context, f func(ctx context.Context, cancel context.CancelFunc) (d interface{}, e error)) (data interface{}, err error) {
+// ATE command function
+func ATE(ctx context.Context, handler *AtCommandHandler, enableOrDisableEcho bool) error {
 
-	// Drain the serial port before executing
-	for {
+	cmd := "ATE0"
 
-		str, err := atCommandHandler.reader.ReadString('\r')
-		str = strings.TrimSpace(str)
-		if str != "" && IsDebugMode() {
-			atCommandHandler.logger.DebugF("Drained following garbage: %v from serial port", str)
-		}
-
-		if err != nil {
-			break
-		}
+	if enableOrDisableEcho {
+		cmd = "ATE1"
 	}
 
-	commandCtx, cancel := ATCreateCommandContext(parentCtx)
-	return f(commandCtx, cancel)
+	_, err := handler.Command(ctx, cmd)
+	return err
 }
 
-// AtHandle Structure
-type AtHandle struct {
-	Command string
-	ctx     context.Context
-	cancel  context.CancelFunc
-	handler func(line string) (completed bool, flow bool, err error)
-}
+// ATCMEE command function
+func ATCMEE(ctx context.Context, handler *AtCommandHandler, level int) error {
 
-// ErrCommandCancelled the command is cancelled
-var ErrCommandCancelled = errors.New("Cancelled")
+	_, err := handler.Command(ctx, fmt.Sprintf("AT+CMEE=%v", level))
+	return err
+}
 
-// Execute the at command
-func (atCommand *AtHandle) Execute(handler *AtCommandHandler) error {
+//CsqResult type
+type CsqResult struct {
+	Csq int
+	Ber int
+}
 
-	cmd := atCommand.Command + "\r"
-	cmdBytes := []byte(cmd)
+// ATCSQ command function
+func ATCSQ(ctx context.Context, handler *AtCommandHandler) (CsqResult, error) {
 
-	n, err := handler.writer.Write(cmdBytes)
+	response, err := handler.Command(ctx, "AT+CSQ")
 
 	if err != nil {
-		return err
+		return CsqResult{}, err
 	}
 
-	if n != len(cmdBytes) {
-		return errors.New("invallid data length")
+	// A missing or malformed line keeps the defaults
+	if line, err := response.First("+CSQ:"); err == nil {
+		if res, err := parseCsqLine(line); err == nil {
+			return res, nil
+		}
 	}
 
-	// Try to sleep a while
-	time.Sleep(500 * time.Millisecond)
+	return CsqResult{}, nil
+}
 
-	str, err := handler.reader.ReadString('\r')
-	str = strings.TrimSpace(str)
+// ATCNSMOD command function
+func ATCNSMOD(ctx context.Context, handler *AtCommandHandler) (AccessTechnology, error) {
 
-	for {
+	response, err := handler.Command(ctx, "AT+CNSMOD?")
 
-		select {
-		case <-atCommand.ctx.Done():
+	if err != nil {
+		return AccessTechnology{}, err
+	}
 
-			if IsDebugMode() {
-				fmt.Printf("timing failure in command: %v\r\n", atCommand.Command)
-			}
+	line, err := response.First("+CNSMOD:")
 
-			return ErrCommandCancelled
-		default:
+	if err != nil {
+		return AccessTechnology{}, err
+	}
 
-			if err != nil {
-				return err
-			}
+	return parseCnsmodLine(line)
+}
 
-			completed, continueFlow, err := atCommand.handler(str)
+// CopsResult type, AccessTechnology is -1 when the modem does not report it
+type CopsResult struct {
+	Mode             int
+	Format           int
+	Operator         string
+	AccessTechnology int
+}
 
-			if err != nil && completed {
-				return err
-			}
+// ATCOPS command function
+func ATCOPS(ctx context.Context, handler *AtCommandHandler) (CopsResult, error) {
 
-			if !continueFlow {
-				return nil
-			}
+	response, err := handler.Command(ctx, "AT+COPS?")
 
-			str, err = handler.reader.ReadString('\r')
-			str = strings.TrimSpace(str)
-		}
+	if err != nil {
+		return CopsResult{}, err
 	}
-}
 
-// AT command function
-func AT(parentCtx context.Context, handler *AtCommandHandler) error {
+	line, err := response.First("+COPS:")
 
-	return handler.HandleCommand(parentCtx, func(ctx context.Context, cancel context.CancelFunc) error {
-
-		command := &AtHandle{
-			Command: "AT",
-			ctx:     ctx,
-			cancel:  cancel,
-			handler: DefaultATHandler(),
-		}
+	if err != nil {
+		return CopsResult{}, err
+	}
 
-		return command.Execute(handler)
-	})
+	return parseCopsLine(line)
 }
 
-// ATE command function
-func ATE(parentCtx context.Context, handler *AtCommandHandler, enableOrDisableEcho bool) error {
-
-	return handler.HandleCommand(parentCtx, func(ctx context.Context, cancel context.CancelFunc) error {
+// ATAccessTechnology returns the access technology of AT+CNSMOD?, modules without it or reporting an unknown mode
+// fall back to the access technology of AT+COPS?
+func ATAccessTechnology(ctx context.Context, handler *AtCommandHandler) (AccessTechnology, error) {
 
-		cmd := ""
+	accessTechnology, err := ATCNSMOD(ctx, handler)
 
-		if enableOrDisableEcho {
-			cmd = "ATE1"
-		} else {
-			cmd = "ATE0"
-		}
-
-		command := &AtHandle{
-			Command: cmd,
-			ctx:     ctx,
-			cancel:  cancel,
-			handler: DefaultATHandler(),
-		}
+	// A timeout or port error fails the next command as well
+	if err == nil || !isATRecoverable(err) {
+		return accessTechnology, err
+	}
 
-		return command.Execute(handler)
-	})
-}
+	cops, err := ATCOPS(ctx, handler)
 
-// ATCMEE command function
-func ATCMEE(parentCtx context.Context, handler *AtCommandHandler, level int) error {
+	if err != nil {
+		return AccessTechnology{}, err
+	}
 
-	return handler.HandleCommand(parentCtx, func(ctx context.Context, cancel context.CancelFunc) error {
-		cmd := fmt.Sprintf("AT+CMEE=%v", level)
+	// Without an operator there's no network
+	if cops.Operator == "" {
+		return AccessTechnology{}, nil
+	}
 
-		command := &AtHandle{
-			Command: cmd,
-			ctx:     ctx,
-			cancel:  cancel,
-			handler: DefaultATHandler(),
-		}
+	// Modules which only support gsm don't report the access technology
+	if cops.AccessTechnology < 0 {
+		return copsAccessTechnologies[0], nil
+	}
 
-		return command.Execute(handler)
-	})
-}
+	if accessTechnology, ok := copsAccessTechnologies[cops.AccessTechnology]; ok {
+		return accessTechnology, nil
+	}
 
-//CsqResult type
-type CsqResult struct {
-	Csq int
-	Ber int
+	return AccessTechnology{}, &atParseError{text: fmt.Sprintf("unknown cops access technology: %v", cops.AccessTechnology)}
 }
 
-// ATCSQ command function
-func ATCSQ(parentCtx context.Context, handler *AtCommandHandler) (csqRes CsqResult, err error) {
-
-	res, err := handler.HandleCommandWithOutput(parentCtx, func(ctx context.Context, cancel context.CancelFunc) (csq interface{}, err error) {
+// parseCsqLine parses a line like: +CSQ: 18,99
+func parseCsqLine(line string) (CsqResult, error) {
 
-		csqValue := 0
-		berValue := 0
+	items := strings.Split(strings.TrimSpace(strings.TrimPrefix(line, "+CSQ:")), ",")
 
-		command := &AtHandle{
-			Command: "AT+CSQ",
-			ctx:     ctx,
-			cancel:  cancel,
-			handler: ATPrefixHandler("+CSQ:", func(line string) (bool, bool, error) {
+	if len(items) != 2 {
+		return CsqResult{}, fmt.Errorf("malformed csq line: %q", line)
+	}
 
-				items := strings.Split(line, ",")
-				csqStr := strings.Replace(items[0], "+CSQ: ", "", -1)
-				csqValue, _ = strconv.Atoi(csqStr)
-				berValue, _ = strconv.Atoi(items[1])
+	csq, err := strconv.Atoi(strings.TrimSpace(items[0]))
 
-				return ATCompletedReadNext()
-			})}
+	if err != nil {
+		return CsqResult{}, fmt.Errorf("malformed csq line: %q", line)
+	}
 
-		err = command.Execute(handler)
-		res := CsqResult{
-			Csq: csqValue,
-			Ber: berValue}
+	ber, err := strconv.Atoi(strings.TrimSpace(items[1]))
 
-		return res, err
-	})
+	if err != nil {
+		return CsqResult{}, fmt.Errorf("malformed csq line: %q", line)
+	}
 
-	// Type cast magic
-	csq, ok := res.(CsqResult)
+	return CsqResult{Csq: csq, Ber: ber}, nil
+}
 
-	if ok {
-		return csq, err
-	}
+// cnsmodModes are the network modes of the SIMCom AT+CNSMOD? command
+var cnsmodModes = map[int]AccessTechnology{
+	0:  {Name: "", ConnType: ConnTypeNoNetwork},
+	1:  {Name: "GSM", ConnType: ConnType2G},
+	2:  {Name: "GPRS", ConnType: ConnType2G},
+	3:  {Name: "EDGE", ConnType: ConnType2G},
+	4:  {Name: "WCDMA", ConnType: ConnType3G},
+	5:  {Name: "HSDPA", ConnType: ConnType3G},
+	6:  {Name: "HSUPA", ConnType: ConnType3G},
+	7:  {Name: "HSPA", ConnType: ConnType3G},
+	8:  {Name: "LTE", ConnType: ConnType4G},
+	9:  {Name: "TDS-CDMA", ConnType: ConnType3G},
+	10: {Name: "TDS-HSDPA", ConnType: ConnType3G},
+	11: {Name: "TDS-HSUPA", ConnType: ConnType3G},
+	12: {Name: "TDS-HSPA", ConnType: ConnType3G},
+	13: {Name: "CDMA", ConnType: ConnType2G},
+	14: {Name: "EVDO", ConnType: ConnType3G},
+	15: {Name: "CDMA/EVDO", ConnType: ConnType3G},
+	16: {Name: "CDMA/LTE", ConnType: ConnType4G},
+	23: {Name: "eHRPD", ConnType: ConnType3G},
+	24: {Name: "CDMA/eHRPD", ConnType: ConnType3G},
+}
 
-	return CsqResult{}, err
+// copsAccessTechnologies are the access technologies of the AT+COPS? command in 3GPP TS 27.007
+var copsAccessTechnologies = map[int]AccessTechnology{
+	0:  {Name: "GSM", ConnType: ConnType2G},
+	1:  {Name: "GSM Compact", ConnType: ConnType2G},
+	2:  {Name: "UTRAN", ConnType: ConnType3G},
+	3:  {Name: "EDGE", ConnType: ConnType2G},
+	4:  {Name: "HSDPA", ConnType: ConnType3G},
+	5:  {Name: "HSUPA", ConnType: ConnType3G},
+	6:  {Name: "HSPA", ConnType: ConnType3G},
+	7:  {Name: "LTE", ConnType: ConnType4G},
+	8:  {Name: "EC-GSM-IoT", ConnType: ConnType2G},
+	9:  {Name: "NB-IoT", ConnType: ConnType4G},
+	10: {Name: "LTE 5GC", ConnType: ConnType4G},
+	11: {Name: "NR 5GC", ConnType: ConnType5G},
+	12: {Name: "NG-RAN", ConnType: ConnType5G},
+	13: {Name: "EN-DC", ConnType: ConnType5G},
+}
 
+// atParseError is returned when a response line cannot be parsed
+type atParseError struct {
+	text string
 }
 
-// ATCNSMOD command function
-func ATCNSMOD(parentCtx context.Context, handler *AtCommandHandler) (bct BroadbandConnType, err error) {
+func (parseError *atParseError) Error() string {
+	return parseError.text
+}
 
-	res, err := handler.HandleCommandWithOutput(parentCtx, func(ctx context.Context, cancel context.CancelFunc) (ct interface{}, err error) {
+// isATRecoverable returns true for the errors of a command which don't affect the next command
+func isATRecoverable(err error) bool {
 
-		ct = ConnTypeNoNetwork
-		command := &AtHandle{
-			Command: "AT+CNSMOD?",
-			ctx:     ctx,
-			cancel:  cancel,
-			handler: ATPrefixHandler("+CNSMOD", func(line string) (bool, bool, error) {
+	switch err.(type) {
+	case *SimError, *ATError, *atParseError:
+		return true
+	}
 
-				items := strings.Split(line, ",")
-				n, err := strconv.Atoi(items[1])
+	return false
+}
 
-				if err == nil && n > 0 {
+// parseCnsmodLine parses a line like: +CNSMOD: 0,5
+func parseCnsmodLine(line string) (AccessTechnology, error) {
 
-					if n <= 3 {
-						ct = ConnType2G
-					} else {
-						ct = ConnType3G
-					}
-					// Todo: Add 4G support
-				}
+	items := strings.Split(line, ",")
 
-				return ATCompletedReadNext()
-			})}
+	if len(items) < 2 {
+		return AccessTechnology{}, &atParseError{text: fmt.Sprintf("malformed cnsmod line: %q", line)}
+	}
 
-		err = command.Execute(handler)
+	n, err := strconv.Atoi(strings.TrimSpace(items[1]))
 
-		return ct, err
-	})
+	if err != nil {
+		return AccessTechnology{}, &atParseError{text: fmt.Sprintf("malformed cnsmod line: %q", line)}
+	}
 
-	// Type cast magic
-	bctr, ok := res.(BroadbandConnType)
+	accessTechnology, ok := cnsmodModes[n]
 
-	if ok {
-		return bctr, err
+	if !ok {
+		return AccessTechnology{}, &atParseError{text: fmt.Sprintf("unknown cnsmod mode: %v", n)}
 	}
 
-	return ConnTypeNoNetwork, err
+	return accessTechnology, nil
 }
 
-func getCcidFromCcidLine(line string) string {
+// parseCopsLine parses a line like: +COPS: 0,0,"KPN NL",7
+func parseCopsLine(line string) (CopsResult, error) {
 
-	items := strings.Split(line, ",")
-	ccIDStr := strings.Replace(items[0], "+CCID: ", "", -1)
+	items := splitATParameters(strings.TrimPrefix(line, "+COPS:"))
+	result := CopsResult{AccessTechnology: -1}
 
-	return strings.TrimRight(strings.TrimLeft(ccIDStr, "\""), "\"")
-}
+	if len(items) == 0 {
+		return result, &atParseError{text: fmt.Sprintf("malformed cops line: %q", line)}
+	}
 
-// ATCCID command function
-func ATCCID(parentCtx context.Context, handler *AtCommandHandler) (ccid string, err error) {
+	values := []*int{&result.Mode, &result.Format, nil, &result.AccessTechnology}
 
-	res, err := handler.HandleCommandWithOutput(parentCtx, func(ctx context.Context, cancel context.CancelFunc) (res interface{}, err error) {
+	for i, item := range items {
 
-		command := &AtHandle{
-			Command: "AT+CCID",
-			ctx:     ctx,
-			cancel:  cancel,
-			handler: ATPrefixHandler("+CCID:", func(line string) (bool, bool, error) {
+		if i == 2 {
+			result.Operator = item
+			continue
+		}
 
-				ccid = getCcidFromCcidLine(line)
-				return ATCompletedReadNext()
-			})}
+		if i >= len(values) {
+			break
+		}
 
-		err = command.Execute(handler)
-		return ccid, err
-	})
+		value, err := strconv.Atoi(item)
+
+		if err != nil {
+			return CopsResult{AccessTechnology: -1}, &atParseError{text: fmt.Sprintf("malformed cops line: %q", line)}
+		}
 
-	result, ok := res.(string)
-	if ok {
-		return result, nil
+		*values[i] = value
 	}
 
-	return "", errors.New("cast failure")
+	return result, nil
 }
 
-// ATCPIN command function
-func ATCPIN(parentCtx context.Context, handler *AtCommandHandler) error {
-
-	return handler.HandleCommand(parentCtx, func(ctx context.Context, cancel context.CancelFunc) error {
-
-		cmd := "AT+CPIN?"
-
-		command := &AtHandle{
-			Command: cmd,
-			ctx:     ctx,
-			cancel:  cancel,
-			handler: ATPrefixHandler("+CPIN:", func(line string) (bool, bool, error) {
-
-				line = strings.Replace(line, "+CPIN: ", "", -1)
-				line = strings.ToUpper(line)
-
-				switch line {
-
-				case "READY":
-					return ATReadNextLine()
-				case "SIM PIN":
-					return ATErrorNext(ErrorFromSimState(PinLocked), true)
-				case "SIM PUK":
-					return ATErrorNext(ErrorFromSimState(PinLocked), true)
-				case "SIM PIN2":
-					return ATErrorNext(ErrorFromSimState(PinLocked2), true)
-				case "SIM PUK2":
-					return ATErrorNext(ErrorFromSimState(PukLocked2), true)
-				default:
-					return ATErrorNext(ErrorFromSimState(UnkownState), true)
-				}
-			})}
-
-		return command.Execute(handler)
-	})
-}
+// splitATParameters splits the comma separated parameters of a response line, a quoted parameter may contain commas
+func splitATParameters(line string) []string {
 
-// ATReadNextLine we want more data
-func ATReadNextLine() (completed bool, flow bool, err error) {
-	return false, true, nil
-}
+	var items []string
+	var item strings.Builder
 
-// ATCheckOk checks for OK messages
-func ATCheckOk(line string) (flow bool) {
+	quoted := false
+	line = strings.TrimSpace(line)
 
-	// Check if we're ok
-	if line == "OK" {
-		return true
+	if line == "" {
+		return nil
 	}
 
-	return false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			items = append(items, strings.TrimSpace(item.String()))
+			item.Reset()
+		default:
+			item.WriteRune(r)
+		}
+	}
 
+	return append(items, strings.TrimSpace(item.String()))
 }
 
-// DefaultATHandler use default at handling mechanism
-func DefaultATHandler() func(line string) (completed bool, flow bool, err error) {
+func getCcidFromCcidLine(line string) string {
 
-	return func(line string) (completed bool, flow bool, err error) {
+	items := strings.Split(line, ",")
+	ccIDStr := strings.Replace(items[0], "+CCID: ", "", -1)
 
-		err = DefaultATErrorHandler(line)
+	return strings.TrimRight(strings.TrimLeft(ccIDStr, "\""), "\"")
+}
 
-		// First run the default error handler
-		if err != nil {
-			return ATError(err)
-		}
+// ATCCID command function
+func ATCCID(ctx context.Context, handler *AtCommandHandler) (string, error) {
 
-		// Check if we're ok
-		if ATCheckOk(line) {
-			return ATCompleted()
-		}
+	response, err := handler.Command(ctx, "AT+CCID")
 
-		//Skip a line and check again
-		return ATReadNextLine()
+	if err != nil {
+		return "", err
 	}
-}
 
-// ATPrefixHandler use default at handling mechanism
-func ATPrefixHandler(prefix string, prefixFunc func(line string) (completed bool, flow bool, err error)) func(line string) (completed bool, flow bool, err error) {
+	// A modem without a sim responds with OK only
+	line, err := response.First("+CCID:")
 
-	return func(line string) (completed bool, flow bool, err error) {
-
-		err = DefaultATErrorHandler(line)
+	if err != nil {
+		return "", nil
+	}
 
-		if err != nil {
-			return ATError(err)
-		}
+	return getCcidFromCcidLine(line), nil
+}
 
-		// Check if we're matching prefix
-		if strings.HasPrefix(line, prefix) {
-			return prefixFunc(line)
-		}
+// ATCPIN command function
+func ATCPIN(ctx context.Context, handler *AtCommandHandler) error {
 
-		// Check if we're ok
-		if ATCheckOk(line) {
-			return ATCompleted()
-		}
+	response, err := handler.Command(ctx, "AT+CPIN?")
 
-		//Skip a line and check again
-		return ATCompletedReadNext()
+	if err != nil {
+		return err
 	}
-}
 
-// DefaultATErrorHandler default logic for error handling
-func DefaultATErrorHandler(line string) error {
+	// Without a state line the sim state is unknown
+	state, _ := response.First("+CPIN:")
 
-	if strings.HasPrefix(line, "ERROR") {
-		return ErrorFromATText("unspecified error (try to set error format for more information)")
-	}
-
-	if strings.HasPrefix(line, "+CME ERROR: ") {
-		text := strings.Replace(line, "+CME ERROR: ", "", -1)
-		return ErrorFromATText(text)
+	switch strings.ToUpper(state) {
+	case "READY":
+		return nil
+	case "SIM PIN":
+		return ErrorFromSimState(PinLocked)
+	case "SIM PUK":
+		return ErrorFromSimState(PukLocked)
+	case "SIM PIN2":
+		return ErrorFromSimState(PinLocked2)
+	case "SIM PUK2":
+		return ErrorFromSimState(PukLocked2)
+	default:
+		return ErrorFromSimState(UnkownState)
 	}
-
-	return nil
 }
 
-// ATCompleted we are completed and expect no more data
-func ATCompleted() (completed bool, flow bool, err error) {
-	return true, false, nil
-}
+// ATRaw sends a raw command and returns every line received up to and including the final result
+func ATRaw(ctx context.Context, handler *AtCommandHandler, cmd string) ([]string, error) {
 
-// ATCompletedReadNext we parsed as completed but we expect more commands
-func ATCompletedReadNext() (completed bool, flow bool, err error) {
-	return true, true, nil
-}
+	response, err := handler.Transaction(ctx, cmd)
 
-// ATError we are completed but have an error
-func ATError(cause error) (completed bool, flow bool, err error) {
-	return true, false, cause
-}
+	if err != nil {
+		return nil, err
+	}
 
-// ATErrorNext we are completed but have an error and expect more data
-func ATErrorNext(cause error, next bool) (completed bool, flow bool, err error) {
-	return true, next, cause
+	return append(response.Lines, response.Final), response.Err()
 }
 
 // SimErrorState value
@@ -609,11 +612,6 @@ func ErrorFromSimState(errorState SimErrorState) error {
 	return &SimError{error: fmt.Sprintf("Got sim error: %v", errorState)}
 }
 
-// ErrorFromATText creates error from sim state
-func ErrorFromATText(err string) error {
-	return &SimError{error: err}
-}
-
 func (simError *SimError) Error() string {
 	return simError.error
 }
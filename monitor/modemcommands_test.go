@@ -1,8 +1,6 @@
 package main
 
 import (
-	"strconv"
-	"strings"
 	"testing"
 )
 
@@ -21,20 +19,111 @@ func TestGetCcidFromCcidLine(t *testing.T) {
 
 func TestCnsMod(t *testing.T) {
 
-	str := "+CNSMOD: 0,5"
-	ct := ConnTypeNoNetwork
+	tests := []struct {
+		line     string
+		expected BroadbandConnType
+		valid    bool
+	}{
+		{"+CNSMOD: 0,5", ConnType3G, true},
+		{"+CNSMOD: 0,2", ConnType2G, true},
+		{"+CNSMOD: 0,0", ConnTypeNoNetwork, true},
+		{"+CNSMOD: 0,8", ConnType4G, true},
+		{"+CNSMOD: 0,16", ConnType4G, true},
+		{"+CNSMOD: 0,12", ConnType3G, true},
+		{"+CNSMOD: 0,99", ConnTypeNoNetwork, false},
+		{"+CNSMOD: 0", ConnTypeNoNetwork, false},
+		{"+CNSMOD: 0,x", ConnTypeNoNetwork, false},
+	}
+
+	for _, test := range tests {
+
+		accessTechnology, err := parseCnsmodLine(test.line)
+
+		if (err == nil) != test.valid {
+			t.Errorf("Line: %q expected valid: %v got error: %v", test.line, test.valid, err)
+		}
+
+		if accessTechnology.ConnType != test.expected {
+			t.Errorf("Invallid connection type for: %q expected: %v but got: %v", test.line, test.expected, accessTechnology.ConnType)
+		}
+	}
+}
 
-	items := strings.Split(str, ",")
-	n, err := strconv.Atoi(items[1])
-	if err == nil && n > 0 {
-		if n <= 3 {
-			ct = ConnType2G
-		} else {
-			ct = ConnType3G
+func TestParseCopsLine(t *testing.T) {
+
+	tests := []struct {
+		line     string
+		expected CopsResult
+		valid    bool
+	}{
+		{"+COPS: 0,0,\"KPN NL\",7", CopsResult{Mode: 0, Format: 0, Operator: "KPN NL", AccessTechnology: 7}, true},
+		{"+COPS: 0,2,\"20404\",2", CopsResult{Mode: 0, Format: 2, Operator: "20404", AccessTechnology: 2}, true},
+		{"+COPS: 1,0,\"T-Mobile, NL\"", CopsResult{Mode: 1, Format: 0, Operator: "T-Mobile, NL", AccessTechnology: -1}, true},
+		{"+COPS: 0", CopsResult{Mode: 0, AccessTechnology: -1}, true},
+		{"+COPS:", CopsResult{AccessTechnology: -1}, false},
+		{"+COPS: 0,0,\"KPN\",x", CopsResult{AccessTechnology: -1}, false},
+	}
+
+	for _, test := range tests {
+
+		res, err := parseCopsLine(test.line)
+
+		if (err == nil) != test.valid {
+			t.Errorf("Line: %q expected valid: %v got error: %v", test.line, test.valid, err)
+		}
+
+		if res != test.expected {
+			t.Errorf("Line: %q expected: %+v got: %+v", test.line, test.expected, res)
 		}
 	}
+}
+
+func TestBroadbandConnectionTypeRoundTrip(t *testing.T) {
 
-	if ct != ConnType3G {
-		t.Errorf("Invallid connection type expected: %v but got: %v", ConnType3G, ct)
+	for _, connType := range []BroadbandConnType{ConnTypeNoNetwork, ConnType2G, ConnType3G, ConnType4G, ConnType5G, ConnType2G} {
+
+		msg := NewMessage()
+		msg.ConnectionStatus().SetBroadbandConnectionType(connType)
+
+		if got := msg.ConnectionStatus().GetBroadbandConnectionType(); got != connType {
+			t.Errorf("Expected broadband type: %v got: %v", connType, got)
+		}
+	}
+
+	// A receiver which only knows the 3G bits still sees 3G
+	msg := NewMessage()
+	msg.ConnectionStatus().SetBroadbandConnectionType(ConnType4G)
+	msg.Data[3] &^= 1 << 3
+
+	if got := msg.ConnectionStatus().GetBroadbandConnectionType(); got != ConnType3G {
+		t.Errorf("Expected the 4G bits to include the 3G bits got: %v", got)
+	}
+}
+
+func TestParseCsqLine(t *testing.T) {
+
+	tests := []struct {
+		line     string
+		expected CsqResult
+		valid    bool
+	}{
+		{"+CSQ: 18,99", CsqResult{Csq: 18, Ber: 99}, true},
+		{"+CSQ: 31,0", CsqResult{Csq: 31, Ber: 0}, true},
+		{"+CSQ: 18", CsqResult{}, false},
+		{"+CSQ:", CsqResult{}, false},
+		{"+CSQ: a,b", CsqResult{}, false},
+	}
+
+	for _, test := range tests {
+
+		res, err := parseCsqLine(test.line)
+
+		if (err == nil) != test.valid {
+			t.Errorf("Line: %q expected valid: %v got error: %v", test.line, test.valid, err)
+		}
+
+		if res != test.expected {
+			t.Errorf("Line: %q expected: %+v got: %+v", test.line, test.expected, res)
+		}
 	}
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Monitor is implemented by every watcher feeding the status message
+type Monitor interface {
+	// Name returns the unique name of the monitor which is used in the configuration
+	Name() string
+	// Run runs the monitor and blocks until the context is cancelled
+	Run(ctx context.Context)
+	// Events returns the events of the monitor, nil when the monitor does not produce events
+	Events() <-chan MonitorEvent
+}
+
+// EventObserver is implemented by monitors which react on the events of the other monitors
+type EventObserver interface {
+	// Observe is called from the message loop and must not block
+	Observe(event MonitorEvent)
+}
+
+// MonitorEvent structure, the payload is one of the monitor messages like EthernetMessage
+type MonitorEvent struct {
+	Source  string
+	Time    time.Time
+	Payload interface{}
+}
+
+// MonitorDependencies structure passed to every monitor factory
+type MonitorDependencies struct {
+	Logger   *Logger
+	Store    *ConfigurationStore
+	Recorder *StatusRecorder
+}
+
+// MonitorFactory creates a monitor
+type MonitorFactory func(dependencies MonitorDependencies) Monitor
+
+// MonitorRegistry holds the factories of all known monitors
+type MonitorRegistry struct {
+	factories map[string]MonitorFactory
+}
+
+// monitorRegistry is filled by the init functions of the monitors
+var monitorRegistry = NewMonitorRegistry()
+
+// NewMonitorRegistry creates an empty registry
+func NewMonitorRegistry() *MonitorRegistry {
+	return &MonitorRegistry{factories: make(map[string]MonitorFactory)}
+}
+
+// RegisterMonitor registers a monitor in the default registry
+func RegisterMonitor(name string, factory MonitorFactory) {
+	monitorRegistry.Register(name, factory)
+}
+
+// Register adds a monitor factory to the registry
+func (registry *MonitorRegistry) Register(name string, factory MonitorFactory) {
+
+	if _, ok := registry.factories[name]; ok {
+		panic(fmt.Sprintf("monitor: %v registered twice", name))
+	}
+
+	registry.factories[name] = factory
+}
+
+// Has returns true when a monitor with the name is registered
+func (registry *MonitorRegistry) Has(name string) bool {
+	_, ok := registry.factories[name]
+	return ok
+}
+
+// Names returns the sorted names of all registered monitors
+func (registry *MonitorRegistry) Names() []string {
+
+	names := make([]string, 0, len(registry.factories))
+
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Create creates all monitors which are enabled
+func (registry *MonitorRegistry) Create(dependencies MonitorDependencies, enabled func(name string) bool) []Monitor {
+
+	monitors := []Monitor{}
+
+	for _, name := range registry.Names() {
+
+		if !enabled(name) {
+			if IsDebugMode() {
+				dependencies.Logger.Debugf("Monitor: %v is disabled by configuration", name)
+			}
+			continue
+		}
+
+		monitors = append(monitors, registry.factories[name](dependencies))
+	}
+
+	return monitors
+}
+
+// publishEvent publishes a payload as event, it returns false when the context is cancelled
+func publishEvent(ctx context.Context, events chan<- MonitorEvent, source string, payload interface{}) bool {
+	return forwardEvent(ctx, events, MonitorEvent{Source: source, Time: time.Now(), Payload: payload})
+}
+
+func forwardEvent(ctx context.Context, events chan<- MonitorEvent, event MonitorEvent) bool {
+
+	select {
+	case <-ctx.Done():
+		return false
+	case events <- event:
+		return true
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, we only publish so we never subscribe
+const (
+	mqttConnect    byte = 1
+	mqttConnack    byte = 2
+	mqttPublish    byte = 3
+	mqttPuback     byte = 4
+	mqttPubrec     byte = 5
+	mqttPubrel     byte = 6
+	mqttPubcomp    byte = 7
+	mqttPingreq    byte = 12
+	mqttPingresp   byte = 13
+	mqttDisconnect byte = 14
+)
+
+// maxMQTTKeepAlive is the largest keep alive the connect packet can hold
+const maxMQTTKeepAlive = 65535 * time.Second
+
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// MQTTWill is published by the broker when the connection is lost without a disconnect
+type MQTTWill struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// MQTTConnectOptions structure
+type MQTTConnectOptions struct {
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	Will      *MQTTWill
+}
+
+// MQTTClient is a minimal MQTT 3.1.1 client which publishes with QoS 0, 1 or 2
+type MQTTClient struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	timeout   time.Duration
+	packetID  uint16
+	lastWrite time.Time
+}
+
+// DialMQTT connects to the broker, every read and write must complete within the timeout
+func DialMQTT(address string, tlsConfig *tls.Config, options MQTTConnectOptions, timeout time.Duration) (*MQTTClient, error) {
+
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	var err error
+
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := &MQTTClient{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+
+	if err := client.connect(options); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// NewMQTTTLSConfig creates the tls configuration of the broker connection, nil when tls is disabled
+func NewMQTTTLSConfig(config MQTTTLSConfiguration) (*tls.Config, error) {
+
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in: %v", config.CAFile)
+		}
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+func (client *MQTTClient) connect(options MQTTConnectOptions) error {
+
+	// Clean session, we don't have subscriptions to keep
+	flags := byte(0x02)
+
+	body := appendMQTTString(nil, []byte("MQTT"))
+	body = append(body, 4)
+
+	if options.Will != nil {
+		flags |= 0x04 | options.Will.QoS<<3
+		if options.Will.Retain {
+			flags |= 0x20
+		}
+	}
+
+	if options.Username != "" {
+		flags |= 0x80
+	}
+
+	if options.Password != "" {
+		flags |= 0x40
+	}
+
+	keepAlive := uint16(options.KeepAlive / time.Second)
+	body = append(body, flags, byte(keepAlive>>8), byte(keepAlive))
+	body = appendMQTTString(body, []byte(options.ClientID))
+
+	if options.Will != nil {
+		body = appendMQTTString(body, []byte(options.Will.Topic))
+		body = appendMQTTString(body, options.Will.Payload)
+	}
+
+	if options.Username != "" {
+		body = appendMQTTString(body, []byte(options.Username))
+	}
+
+	if options.Password != "" {
+		body = appendMQTTString(body, []byte(options.Password))
+	}
+
+	if err := client.write(mqttConnect<<4, body); err != nil {
+		return err
+	}
+
+	ack, err := client.await(mqttConnack)
+
+	if err != nil {
+		return err
+	}
+
+	if len(ack) != 2 {
+		return errors.New("mqtt: invalid connack")
+	}
+
+	if ack[1] != 0 {
+		if reason, ok := mqttConnackErrors[ack[1]]; ok {
+			return fmt.Errorf("mqtt: connection refused: %v", reason)
+		}
+		return fmt.Errorf("mqtt: connection refused: %v", ack[1])
+	}
+
+	return nil
+}
+
+// Publish publishes the payload and waits for the acknowledgements of the QoS
+func (client *MQTTClient) Publish(topic string, payload []byte, qos byte, retain bool) error {
+
+	header := mqttPublish<<4 | qos<<1
+
+	if retain {
+		header |= 0x01
+	}
+
+	body := appendMQTTString(nil, []byte(topic))
+
+	var id uint16
+
+	if qos > 0 {
+		id = client.nextPacketID()
+		body = append(body, byte(id>>8), byte(id))
+	}
+
+	if err := client.write(header, append(body, payload...)); err != nil {
+		return err
+	}
+
+	switch qos {
+	case 1:
+		return client.awaitID(mqttPuback, id)
+	case 2:
+		if err := client.awaitID(mqttPubrec, id); err != nil {
+			return err
+		}
+
+		if err := client.write(mqttPubrel<<4|0x02, []byte{byte(id >> 8), byte(id)}); err != nil {
+			return err
+		}
+
+		return client.awaitID(mqttPubcomp, id)
+	}
+
+	return nil
+}
+
+// Ping keeps the connection alive and checks the broker still responds
+func (client *MQTTClient) Ping() error {
+
+	if err := client.write(mqttPingreq<<4, nil); err != nil {
+		return err
+	}
+
+	_, err := client.await(mqttPingresp)
+	return err
+}
+
+// Idle returns the time since the last packet was written
+func (client *MQTTClient) Idle() time.Duration {
+	return time.Since(client.lastWrite)
+}
+
+// Disconnect closes the connection gracefully, the broker discards the will
+func (client *MQTTClient) Disconnect() error {
+
+	err := client.write(mqttDisconnect<<4, nil)
+	client.conn.Close()
+
+	return err
+}
+
+// Close closes the connection, the broker publishes the will
+func (client *MQTTClient) Close() error {
+	return client.conn.Close()
+}
+
+func (client *MQTTClient) nextPacketID() uint16 {
+
+	// Packet id 0 is not allowed
+	client.packetID++
+
+	if client.packetID == 0 {
+		client.packetID++
+	}
+
+	return client.packetID
+}
+
+func (client *MQTTClient) write(header byte, body []byte) error {
+
+	client.conn.SetWriteDeadline(time.Now().Add(client.timeout))
+	client.lastWrite = time.Now()
+
+	return writeMQTTPacket(client.conn, header, body)
+}
+
+// await reads packets until a packet of the type is received
+func (client *MQTTClient) await(packetType byte) ([]byte, error) {
+
+	client.conn.SetReadDeadline(time.Now().Add(client.timeout))
+
+	for {
+		header, body, err := readMQTTPacket(client.reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if header>>4 == packetType {
+			return body, nil
+		}
+
+		// A late ping response is harmless, anything else means we're out of sync
+		if header>>4 != mqttPingresp {
+			return nil, fmt.Errorf("mqtt: expected packet type: %v got: %v", packetType, header>>4)
+		}
+	}
+}
+
+func (client *MQTTClient) awaitID(packetType byte, id uint16) error {
+
+	body, err := client.await(packetType)
+
+	if err != nil {
+		return err
+	}
+
+	if len(body) != 2 || uint16(body[0])<<8|uint16(body[1]) != id {
+		return fmt.Errorf("mqtt: expected acknowledgement of packet: %v got: % x", id, body)
+	}
+
+	return nil
+}
+
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+
+	packet := append([]byte{header}, encodeMQTTLength(len(body))...)
+	_, err := w.Write(append(packet, body...))
+
+	return err
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+
+	header, err := r.ReadByte()
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+
+	for multiplier := 1; ; multiplier *= 128 {
+
+		if multiplier > 128*128*128 {
+			return 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+
+		digit, err := r.ReadByte()
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length += int(digit&0x7f) * multiplier
+
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header, body, nil
+}
+
+func encodeMQTTLength(length int) []byte {
+
+	var encoded []byte
+
+	for {
+		digit := byte(length % 128)
+		length /= 128
+
+		if length > 0 {
+			digit |= 0x80
+		}
+
+		encoded = append(encoded, digit)
+
+		if length == 0 {
+			return encoded
+		}
+	}
+}
+
+func appendMQTTString(b []byte, value []byte) []byte {
+	b = append(b, byte(len(value)>>8), byte(len(value)))
+	return append(b, value...)
+}
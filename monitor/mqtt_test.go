@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+type mqttTestPublish struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+// mqttTestBroker accepts connections and acknowledges everything it receives
+type mqttTestBroker struct {
+	listener  net.Listener
+	connects  chan []byte
+	publishes chan mqttTestPublish
+	conns     chan net.Conn
+}
+
+func startMQTTTestBroker(t *testing.T) *mqttTestBroker {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Cannot start broker: %v", err)
+	}
+
+	broker := &mqttTestBroker{
+		listener:  listener,
+		connects:  make(chan []byte, 10),
+		publishes: make(chan mqttTestPublish, 100),
+		conns:     make(chan net.Conn, 10),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			broker.conns <- conn
+			go broker.serve(conn)
+		}
+	}()
+
+	return broker
+}
+
+func (broker *mqttTestBroker) serve(conn net.Conn) {
+
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for {
+		header, body, err := readMQTTPacket(reader)
+
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case mqttConnect:
+			broker.connects <- body
+			writeMQTTPacket(conn, mqttConnack<<4, []byte{0, 0})
+		case mqttPublish:
+			qos := header >> 1 & 0x03
+			length := int(body[0])<<8 | int(body[1])
+			publish := mqttTestPublish{topic: string(body[2 : 2+length]), qos: qos, retain: header&0x01 != 0}
+			body = body[2+length:]
+
+			if qos > 0 {
+				id := body[:2]
+				body = body[2:]
+
+				if qos == 1 {
+					writeMQTTPacket(conn, mqttPuback<<4, id)
+				} else {
+					writeMQTTPacket(conn, mqttPubrec<<4, id)
+				}
+			}
+
+			publish.payload = string(body)
+			broker.publishes <- publish
+		case mqttPubrel:
+			writeMQTTPacket(conn, mqttPubcomp<<4, body)
+		case mqttPingreq:
+			writeMQTTPacket(conn, mqttPingresp<<4, nil)
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func (broker *mqttTestBroker) next(t *testing.T) mqttTestPublish {
+
+	select {
+	case publish := <-broker.publishes:
+		return publish
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for a publish")
+		return mqttTestPublish{}
+	}
+}
+
+func TestMQTTLengthRoundTrip(t *testing.T) {
+
+	for _, length := range []int{0, 1, 127, 128, 16383, 16384, 2097151, 2097152} {
+
+		buffer := &bytes.Buffer{}
+
+		if err := writeMQTTPacket(buffer, mqttPublish<<4, make([]byte, length)); err != nil {
+			t.Fatalf("Cannot write packet: %v", err)
+		}
+
+		header, body, err := readMQTTPacket(bufio.NewReader(buffer))
+
+		if err != nil || header != mqttPublish<<4 || len(body) != length {
+			t.Errorf("Expected length: %v got: %v header: %x error: %v", length, len(body), header, err)
+		}
+	}
+}
+
+func TestMQTTClientPublish(t *testing.T) {
+
+	broker := startMQTTTestBroker(t)
+	defer broker.listener.Close()
+
+	options := MQTTConnectOptions{
+		ClientID:  "device",
+		Username:  "user",
+		Password:  "secret",
+		KeepAlive: time.Minute,
+		Will:      &MQTTWill{Topic: "device/availability", Payload: []byte("offline"), QoS: 1, Retain: true},
+	}
+
+	client, err := DialMQTT(broker.listener.Addr().String(), nil, options, 5*time.Second)
+
+	if err != nil {
+		t.Fatalf("Cannot connect: %v", err)
+	}
+
+	defer client.Close()
+
+	connect := <-broker.connects
+
+	// Protocol name, level 4, flags: user, password, will retain, will qos 1, will and clean session
+	if !bytes.Equal(connect[:10], []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0xee, 0, 60}) {
+		t.Errorf("Unexpected connect header: % x", connect[:10])
+	}
+
+	if !bytes.Contains(connect, []byte("device/availability")) || !bytes.Contains(connect, []byte("secret")) {
+		t.Errorf("Expected will and credentials in connect: %q", connect)
+	}
+
+	for qos := byte(0); qos <= 2; qos++ {
+
+		if err := client.Publish("device/status", []byte("payload"), qos, qos == 1); err != nil {
+			t.Fatalf("Cannot publish with qos: %v %v", qos, err)
+		}
+
+		expected := mqttTestPublish{topic: "device/status", payload: "payload", qos: qos, retain: qos == 1}
+
+		if publish := broker.next(t); publish != expected {
+			t.Errorf("Expected: %+v got: %+v", expected, publish)
+		}
+	}
+
+	if err := client.Ping(); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+}
+
+func TestMQTTPublisher(t *testing.T) {
+
+	broker := startMQTTTestBroker(t)
+	defer broker.listener.Close()
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	config := DefaultConfiguration()
+	config.MQTT.Enabled = true
+	config.MQTT.Address = broker.listener.Addr().String()
+	config.MQTT.ClientID = "device"
+	config.MQTT.ReconnectInterval = 10 * time.Millisecond
+
+	store := NewConfigurationStore("", config)
+	publisher := NewMQTTPublisher(logger, store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go publisher.Run(ctx)
+
+	online := mqttTestPublish{topic: "rm-monitor/device/availability", payload: "online", qos: 1, retain: true}
+
+	if publish := broker.next(t); publish != online {
+		t.Fatalf("Expected: %+v got: %+v", online, publish)
+	}
+
+	msg := NewMessage()
+	msg.ConnectionStatus().SetEth0Status(true)
+	modem := &RecordedValue{Received: time.Now(), Value: ModemStatusMessage{ModemAvailable: true, Csq: 20}}
+	publisher.Publish(msg, StatusSnapshot{Modem: modem})
+
+	if publish := broker.next(t); publish.topic != "rm-monitor/device/events" || publish.retain {
+		t.Errorf("Expected a status change event got: %+v", publish)
+	}
+
+	state := broker.next(t)
+	decoded := MessageSnapshot{}
+
+	if err := json.Unmarshal([]byte(state.payload), &decoded); err != nil || state.topic != "rm-monitor/device/status" || !state.retain {
+		t.Fatalf("Expected the retained status got: %+v error: %v", state, err)
+	}
+
+	if !decoded.Decoded.Connection.Eth0 {
+		t.Errorf("Expected eth0 to be connected got: %+v", decoded.Decoded.Connection)
+	}
+
+	if publish := broker.next(t); publish.topic != "rm-monitor/device/modem" || !publish.retain {
+		t.Errorf("Expected the retained modem info got: %+v", publish)
+	}
+
+	// An unchanged message and modem info is not published again
+	publisher.Publish(msg, StatusSnapshot{Modem: modem})
+
+	// The lost connection is noticed on the next publish, the retained state is published again after the reconnect
+	(<-broker.conns).Close()
+	msg.ConnectionStatus().SetEth1Status(true)
+	publisher.Publish(msg, StatusSnapshot{Modem: modem})
+
+	if publish := broker.next(t); publish != online {
+		t.Fatalf("Expected: %+v got: %+v", online, publish)
+	}
+
+	republished := map[string]bool{}
+
+	for i := 0; i < 3; i++ {
+		republished[broker.next(t).topic] = true
+	}
+
+	if !republished["rm-monitor/device/events"] || !republished["rm-monitor/device/status"] || !republished["rm-monitor/device/modem"] {
+		t.Errorf("Expected the retained topics to be published again got: %v", republished)
+	}
+
+	// A reloaded configuration reconnects with the new client id
+	reloaded := *config
+	reloaded.MQTT.ClientID = "renamed"
+	store.Update(&reloaded)
+
+	offline := mqttTestPublish{topic: "rm-monitor/device/availability", payload: "offline", qos: 1, retain: true}
+
+	if publish := broker.next(t); publish != offline {
+		t.Fatalf("Expected: %+v got: %+v", offline, publish)
+	}
+
+	online.topic = "rm-monitor/renamed/availability"
+
+	if publish := broker.next(t); publish != online {
+		t.Fatalf("Expected: %+v got: %+v", online, publish)
+	}
+
+	cancel()
+
+	offline.topic = "rm-monitor/renamed/availability"
+
+	if publish := broker.next(t); publish != offline {
+		t.Errorf("Expected: %+v got: %+v", offline, publish)
+	}
+
+	select {
+	case <-publisher.Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("Publisher did not stop")
+	}
+}
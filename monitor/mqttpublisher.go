@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errMQTTReconfigured is returned by serve when the mqtt configuration is reloaded
+var errMQTTReconfigured = errors.New("mqtt configuration changed")
+
+// MQTTPublisher publishes the status to an mqtt broker next to the status datagrams, it never blocks the message loop
+type MQTTPublisher struct {
+	logger *Logger
+	store  *ConfigurationStore
+	wake   chan struct{}
+	done   chan struct{}
+
+	mutex            sync.Mutex
+	config           MQTTConfiguration
+	clientID         string
+	changes          statusChanges
+	retained         map[string][]byte
+	pending          []mqttMessage
+	modemReceived    time.Time
+	hostInfoReceived time.Time
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// NewMQTTPublisher creates the publisher, it follows the mqtt configuration of the store
+func NewMQTTPublisher(logger *Logger, store *ConfigurationStore) *MQTTPublisher {
+
+	publisher := &MQTTPublisher{
+		logger:   logger,
+		store:    store,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		retained: make(map[string][]byte),
+	}
+
+	publisher.apply(store.Current().MQTT)
+
+	return publisher
+}
+
+// apply activates the configuration, without a client id the hostname is used
+func (publisher *MQTTPublisher) apply(config MQTTConfiguration) (MQTTConfiguration, string) {
+
+	clientID := config.ClientID
+
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = "rm-monitor-" + hostname
+	}
+
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+
+	// The queued and retained messages are bound to the topics, start over when they change
+	if clientID != publisher.clientID || config.Topics != publisher.config.Topics || !config.Enabled {
+		publisher.changes = statusChanges{}
+		publisher.retained = make(map[string][]byte)
+		publisher.pending = nil
+		publisher.modemReceived = time.Time{}
+		publisher.hostInfoReceived = time.Time{}
+	}
+
+	publisher.config = config
+	publisher.clientID = clientID
+
+	return config, clientID
+}
+
+// Publish queues the changes of the status message and the modem and host info recorded since the previous call
+func (publisher *MQTTPublisher) Publish(msg *Message, snapshot StatusSnapshot) {
+
+	publisher.mutex.Lock()
+
+	if !publisher.config.Enabled {
+		publisher.mutex.Unlock()
+		return
+	}
+
+	topics := publisher.config.Topics
+
+	if event := publisher.changes.Next(msg); event != nil {
+		publisher.queue(topics.Events, event, false)
+		publisher.queue(topics.State, &MessageSnapshot{Sent: event.Time, Raw: hex.EncodeToString(msg.Data[:]), Decoded: event.Status}, true)
+	}
+
+	if snapshot.Modem != nil && snapshot.Modem.Received.After(publisher.modemReceived) {
+		publisher.modemReceived = snapshot.Modem.Received
+		publisher.queue(topics.Modem, snapshot.Modem, true)
+	}
+
+	if snapshot.HostInfo != nil && snapshot.HostInfo.Received.After(publisher.hostInfoReceived) {
+		publisher.hostInfoReceived = snapshot.HostInfo.Received
+		publisher.queue(topics.HostInfo, snapshot.HostInfo, true)
+	}
+
+	publisher.mutex.Unlock()
+
+	select {
+	case publisher.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queue adds the value to the pending messages, the mutex must be held
+func (publisher *MQTTPublisher) queue(topic string, value interface{}, retain bool) {
+
+	if topic == "" {
+		return
+	}
+
+	payload, err := json.Marshal(value)
+
+	if err != nil {
+		publisher.logger.Warningf("Cannot encode mqtt message: %v", err)
+		return
+	}
+
+	topic = expandMQTTTopic(topic, publisher.clientID)
+
+	if retain {
+		publisher.retained[topic] = payload
+
+		// Only the latest state of a topic is worth publishing
+		for i := range publisher.pending {
+			if publisher.pending[i].topic == topic {
+				publisher.pending[i].payload = payload
+				return
+			}
+		}
+	} else if len(publisher.pending) >= publisher.config.Buffer {
+		for i, message := range publisher.pending {
+			if !message.retain {
+				publisher.pending = append(publisher.pending[:i], publisher.pending[i+1:]...)
+				metrics.MQTTDropped.Inc()
+				break
+			}
+		}
+	}
+
+	publisher.pending = append(publisher.pending, mqttMessage{topic: topic, payload: payload, retain: retain})
+}
+
+func expandMQTTTopic(topic string, clientID string) string {
+	return strings.Replace(topic, "{client_id}", clientID, -1)
+}
+
+// Done is closed when the publisher disconnected after the context is cancelled
+func (publisher *MQTTPublisher) Done() <-chan struct{} {
+	return publisher.done
+}
+
+// Run publishes until the context is cancelled, a lost connection is restored after the reconnect interval and
+// a reloaded configuration reconnects directly
+func (publisher *MQTTPublisher) Run(ctx context.Context) {
+
+	defer close(publisher.done)
+
+	failing := false
+
+	for {
+		changed := publisher.store.Changed()
+		config, clientID := publisher.apply(publisher.store.Current().MQTT)
+
+		if !config.Enabled {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				continue
+			}
+		}
+
+		client, err := publisher.connect(config, clientID)
+
+		if err != nil {
+
+			// Only warn on the first failure to prevent flooding the log
+			if !failing {
+				publisher.logger.Warningf("Cannot connect to mqtt broker @ %v: %v", config.Address, err)
+			}
+
+			failing = true
+		} else {
+			failing = false
+			publisher.logger.Infof("Connected to mqtt broker @ %v as: %v", config.Address, clientID)
+
+			metrics.MQTTConnected.Set(1)
+			err = publisher.serve(ctx, changed, client, config)
+			metrics.MQTTConnected.Set(0)
+
+			if ctx.Err() != nil {
+				publisher.disconnect(client, config, clientID)
+				return
+			}
+
+			if err == errMQTTReconfigured {
+				publisher.logger.Infof("Reconnecting to mqtt broker @ %v with the reloaded configuration", config.Address)
+				publisher.disconnect(client, config, clientID)
+				continue
+			}
+
+			metrics.MQTTPublishErrors.Inc()
+			publisher.logger.Warningf("Lost connection to mqtt broker @ %v: %v", config.Address, err)
+			client.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-time.After(config.ReconnectInterval):
+		}
+	}
+}
+
+func (publisher *MQTTPublisher) connect(config MQTTConfiguration, clientID string) (*MQTTClient, error) {
+
+	tlsConfig, err := NewMQTTTLSConfig(config.TLS)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := MQTTConnectOptions{
+		ClientID:  clientID,
+		Username:  config.Username,
+		Password:  config.Password,
+		KeepAlive: config.KeepAlive,
+	}
+
+	if config.Topics.Availability != "" {
+		options.Will = &MQTTWill{Topic: expandMQTTTopic(config.Topics.Availability, clientID), Payload: []byte("offline"), QoS: byte(config.QoS), Retain: true}
+	}
+
+	client, err := DialMQTT(config.Address, tlsConfig, options, config.Timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Will != nil {
+		if err := client.Publish(options.Will.Topic, []byte("online"), byte(config.QoS), true); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// serve publishes the pending messages until the context is cancelled, the configuration changes or the
+// connection fails
+func (publisher *MQTTPublisher) serve(ctx context.Context, changed <-chan struct{}, client *MQTTClient, config MQTTConfiguration) error {
+
+	// The broker may have lost the retained state while we were disconnected
+	publisher.requeueRetained()
+
+	interval := config.KeepAlive / 2
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			message, ok := publisher.next()
+
+			if !ok {
+				break
+			}
+
+			if err := client.Publish(message.topic, message.payload, byte(config.QoS), message.retain); err != nil {
+				publisher.requeue(message)
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+			return errMQTTReconfigured
+		case <-publisher.wake:
+		case <-ticker.C:
+			if client.Idle() >= interval {
+				if err := client.Ping(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// disconnect reports we're going offline, a graceful disconnect discards the will
+func (publisher *MQTTPublisher) disconnect(client *MQTTClient, config MQTTConfiguration, clientID string) {
+
+	if config.Topics.Availability != "" {
+		if err := client.Publish(expandMQTTTopic(config.Topics.Availability, clientID), []byte("offline"), byte(config.QoS), true); err != nil {
+			publisher.logger.Warningf("Cannot publish mqtt availability: %v", err)
+		}
+	}
+
+	client.Disconnect()
+}
+
+func (publisher *MQTTPublisher) next() (mqttMessage, bool) {
+
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+
+	if len(publisher.pending) == 0 {
+		return mqttMessage{}, false
+	}
+
+	message := publisher.pending[0]
+	publisher.pending = publisher.pending[1:]
+
+	return message, true
+}
+
+func (publisher *MQTTPublisher) requeue(message mqttMessage) {
+
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+
+	publisher.pending = append([]mqttMessage{message}, publisher.pending...)
+}
+
+func (publisher *MQTTPublisher) requeueRetained() {
+
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+
+	pending := make(map[string]bool)
+
+	for _, message := range publisher.pending {
+		pending[message.topic] = true
+	}
+
+	topics := make([]string, 0, len(publisher.retained))
+
+	for topic := range publisher.retained {
+		if !pending[topic] {
+			topics = append(topics, topic)
+		}
+	}
+
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		publisher.pending = append(publisher.pending, mqttMessage{topic: topic, payload: publisher.retained[topic], retain: true})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// multicastControl returns the dialer control which sets the ttl and the outgoing interface of a connection to a
+// multicast group, they must be set before connecting as a link-local group is only reachable through the interface
+func multicastControl(target StatusTarget, addr *net.UDPAddr) (func(network string, address string, raw syscall.RawConn) error, error) {
+
+	var ifi *net.Interface
+
+	if target.Interface != "" {
+
+		var err error
+		ifi, err = net.InterfaceByName(target.Interface)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(network string, address string, raw syscall.RawConn) error {
+
+		var optionErr error
+
+		err := raw.Control(func(fd uintptr) {
+			optionErr = setMulticastOptions(fd, addr.IP.To4() == nil, target.TTL, ifi)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return optionErr
+	}, nil
+}
@@ -0,0 +1,38 @@
+// +build linux
+
+package main
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func setMulticastOptions(fd uintptr, ipv6 bool, ttl int, ifi *net.Interface) error {
+
+	if ipv6 {
+		if ttl > 0 {
+			if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ttl); err != nil {
+				return err
+			}
+		}
+
+		if ifi != nil {
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, ifi.Index)
+		}
+
+		return nil
+	}
+
+	if ttl > 0 {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, ttl); err != nil {
+			return err
+		}
+	}
+
+	if ifi != nil {
+		return unix.SetsockoptIPMreqn(int(fd), unix.IPPROTO_IP, unix.IP_MULTICAST_IF, &unix.IPMreqn{Ifindex: int32(ifi.Index)})
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+// +build windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+func setMulticastOptions(fd uintptr, ipv6 bool, ttl int, ifi *net.Interface) error {
+
+	handle := syscall.Handle(fd)
+
+	if ipv6 {
+		if ttl > 0 {
+			if err := syscall.SetsockoptInt(handle, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, ttl); err != nil {
+				return err
+			}
+		}
+
+		if ifi != nil {
+			return syscall.SetsockoptInt(handle, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, ifi.Index)
+		}
+
+		return nil
+	}
+
+	if ttl > 0 {
+		if err := syscall.SetsockoptInt(handle, syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl); err != nil {
+			return err
+		}
+	}
+
+	// Windows accepts an interface index as 0.x.x.x address
+	if ifi != nil {
+		index := ifi.Index
+		return syscall.SetsockoptInet4Addr(handle, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, [4]byte{0, byte(index >> 16), byte(index >> 8), byte(index)})
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RegistrationState type, the network registration of the modem
+type RegistrationState string
+
+const (
+	// RegistrationUnknown the modem does not report the registration
+	RegistrationUnknown RegistrationState = "unknown"
+	// RegistrationNotRegistered the modem is not registered and not searching
+	RegistrationNotRegistered RegistrationState = "not registered"
+	// RegistrationSearching the modem is searching an operator to register to
+	RegistrationSearching RegistrationState = "searching"
+	// RegistrationDenied the network rejected the registration, for example a blocked sim
+	RegistrationDenied RegistrationState = "denied"
+	// RegistrationHome the modem is registered to the home network
+	RegistrationHome RegistrationState = "home"
+	// RegistrationRoaming the modem is registered to a foreign network
+	RegistrationRoaming RegistrationState = "roaming"
+)
+
+// Registered returns true when the modem is registered to a network
+func (registrationState RegistrationState) Registered() bool {
+	return registrationState == RegistrationHome || registrationState == RegistrationRoaming
+}
+
+// registrationStates the <stat> of AT+CREG?, AT+CGREG? and AT+CEREG?
+var registrationStates = map[int]RegistrationState{
+	0: RegistrationNotRegistered,
+	1: RegistrationHome,
+	2: RegistrationSearching,
+	3: RegistrationDenied,
+	4: RegistrationUnknown,
+	5: RegistrationRoaming,
+	// Registered for sms only
+	6: RegistrationHome,
+	7: RegistrationRoaming,
+	// Attached for emergency bearer services only, it is not a rejection
+	8: RegistrationNotRegistered,
+	// Registered without circuit switched fallback
+	9:  RegistrationHome,
+	10: RegistrationRoaming,
+}
+
+// registrationRanks orders the states when the domains disagree, the best state wins
+var registrationRanks = map[RegistrationState]int{
+	RegistrationUnknown:       0,
+	RegistrationNotRegistered: 1,
+	RegistrationDenied:        2,
+	RegistrationSearching:     3,
+	RegistrationRoaming:       4,
+	RegistrationHome:          5,
+}
+
+// registrationCommands query the circuit switched, packet switched and eps registration
+var registrationCommands = []string{"AT+CREG?", "AT+CGREG?", "AT+CEREG?"}
+
+// Operator structure, the network the modem is registered to
+type Operator struct {
+	Name string
+	MCC  string
+	MNC  string
+}
+
+// ATRegistration returns the best registration of AT+CREG?, AT+CGREG? and AT+CEREG?, a 3g module without
+// AT+CEREG? or an lte module which is only registered for eps is still registered
+func ATRegistration(ctx context.Context, handler *AtCommandHandler) (RegistrationState, error) {
+
+	registration := RegistrationUnknown
+	var firstErr error
+	answered := false
+
+	for _, command := range registrationCommands {
+
+		state, err := atRegistrationCommand(ctx, handler, command)
+
+		if err != nil && !isATRecoverable(err) {
+			return RegistrationUnknown, err
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		answered = true
+
+		if registrationRanks[state] > registrationRanks[registration] {
+			registration = state
+		}
+	}
+
+	// A module without one of the commands is not an error
+	if answered {
+		return registration, nil
+	}
+
+	return RegistrationUnknown, firstErr
+}
+
+func atRegistrationCommand(ctx context.Context, handler *AtCommandHandler, command string) (RegistrationState, error) {
+
+	response, err := handler.Command(ctx, command)
+
+	if err != nil {
+		return RegistrationUnknown, err
+	}
+
+	prefix := atResponsePrefix(command)
+	line, err := response.First(prefix)
+
+	if err != nil {
+		return RegistrationUnknown, err
+	}
+
+	return parseRegistrationLine(prefix, line)
+}
+
+// parseRegistrationLine parses the response of a registration query like: +CREG: 0,5 or +CEREG: 2,1,"00C3","0A2B3C4D",7
+func parseRegistrationLine(prefix string, line string) (RegistrationState, error) {
+
+	items := splitATParameters(strings.TrimPrefix(line, prefix))
+
+	if len(items) < 2 {
+		return RegistrationUnknown, &atParseError{text: fmt.Sprintf("malformed registration line: %q", line)}
+	}
+
+	stat, err := strconv.Atoi(items[1])
+
+	if err != nil {
+		return RegistrationUnknown, &atParseError{text: fmt.Sprintf("malformed registration line: %q", line)}
+	}
+
+	registration, ok := registrationStates[stat]
+
+	if !ok {
+		return RegistrationUnknown, &atParseError{text: fmt.Sprintf("unknown registration state: %v", stat)}
+	}
+
+	return registration, nil
+}
+
+// ATOperator returns the name and MCC/MNC of the operator of AT+COPS?, the numeric format is selected first and the
+// long name last so the modem is left in its default format
+func ATOperator(ctx context.Context, handler *AtCommandHandler) (Operator, error) {
+
+	operator := Operator{}
+	var firstErr error
+	answered := false
+
+	for _, format := range []int{2, 0} {
+
+		cops, err := atCopsFormat(ctx, handler, format)
+
+		if err != nil && !isATRecoverable(err) {
+			return Operator{}, err
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		answered = true
+
+		// The modem may ignore the format when it's not supported
+		if cops.Format == 2 {
+			operator.MCC, operator.MNC = splitOperatorNumeric(cops.Operator)
+		} else {
+			operator.Name = cops.Operator
+		}
+	}
+
+	// A module without the numeric format still reports the name
+	if answered {
+		return operator, nil
+	}
+
+	return Operator{}, firstErr
+}
+
+func atCopsFormat(ctx context.Context, handler *AtCommandHandler, format int) (CopsResult, error) {
+
+	if _, err := handler.Command(ctx, fmt.Sprintf("AT+COPS=3,%d", format)); err != nil {
+		return CopsResult{}, err
+	}
+
+	return ATCOPS(ctx, handler)
+}
+
+// splitOperatorNumeric splits an operator like 20408 in the MCC of three digits and the MNC of two or three digits
+func splitOperatorNumeric(numeric string) (mcc string, mnc string) {
+
+	if len(numeric) != 5 && len(numeric) != 6 {
+		return "", ""
+	}
+
+	if _, err := strconv.Atoi(numeric); err != nil {
+		return "", ""
+	}
+
+	return numeric[:3], numeric[3:]
+}
+
+// formatRegistration returns the registration for the logs, for example: roaming on Vodafone NL (204/04)
+func formatRegistration(registration RegistrationState, operator Operator) string {
+
+	if !registration.Registered() || operator.Name == "" {
+		return string(registration)
+	}
+
+	if operator.MCC == "" {
+		return fmt.Sprintf("%v on %v", registration, operator.Name)
+	}
+
+	return fmt.Sprintf("%v on %v (%v/%v)", registration, operator.Name, operator.MCC, operator.MNC)
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestParseRegistrationLine(t *testing.T) {
+
+	tests := []struct {
+		prefix   string
+		line     string
+		expected RegistrationState
+		valid    bool
+	}{
+		{"+CREG:", "+CREG: 0,1", RegistrationHome, true},
+		{"+CREG:", "+CREG: 2,5,\"00C3\",\"0000F3A2\",2", RegistrationRoaming, true},
+		{"+CGREG:", "+CGREG: 0,2", RegistrationSearching, true},
+		{"+CEREG:", "+CEREG: 0,3", RegistrationDenied, true},
+		{"+CEREG:", "+CEREG: 0,0", RegistrationNotRegistered, true},
+		{"+CEREG:", "+CEREG: 0,4", RegistrationUnknown, true},
+		{"+CEREG:", "+CEREG: 0,7", RegistrationRoaming, true},
+		{"+CEREG:", "+CEREG: 0,8", RegistrationNotRegistered, true},
+		{"+CREG:", "+CREG: 1", RegistrationUnknown, false},
+		{"+CREG:", "+CREG: 0,x", RegistrationUnknown, false},
+		{"+CREG:", "+CREG: 0,42", RegistrationUnknown, false},
+	}
+
+	for _, test := range tests {
+
+		registration, err := parseRegistrationLine(test.prefix, test.line)
+
+		if (err == nil) != test.valid {
+			t.Errorf("Line: %q expected valid: %v got error: %v", test.line, test.valid, err)
+		}
+
+		if registration != test.expected {
+			t.Errorf("Line: %q expected: %v got: %v", test.line, test.expected, registration)
+		}
+	}
+}
+
+func TestSplitOperatorNumeric(t *testing.T) {
+
+	tests := []struct {
+		numeric string
+		mcc     string
+		mnc     string
+	}{
+		{"20408", "204", "08"},
+		{"310260", "310", "260"},
+		{"2040", "", ""},
+		{"KPN NL", "", ""},
+	}
+
+	for _, test := range tests {
+		if mcc, mnc := splitOperatorNumeric(test.numeric); mcc != test.mcc || mnc != test.mnc {
+			t.Errorf("Operator: %q expected: %v/%v got: %v/%v", test.numeric, test.mcc, test.mnc, mcc, mnc)
+		}
+	}
+}
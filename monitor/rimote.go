@@ -7,8 +7,17 @@ import (
 	"time"
 )
 
+func init() {
+	RegisterMonitor("rimote", func(dependencies MonitorDependencies) Monitor {
+		return newRimoteMonitor(dependencies.Logger, dependencies.Store)
+	})
+}
+
 // Monitor type
 type rimoteMonitor struct {
+	logger *Logger
+	store  *ConfigurationStore
+	events chan MonitorEvent
 }
 
 // RimoteMessage structure
@@ -23,51 +32,81 @@ type RimoteAPIMesssage struct {
 	HardwareID  string
 }
 
-// MonitorRimoteConnectionStatus handles monitoring of the connection status
-func MonitorRimoteConnectionStatus(ctx context.Context, logger *Logger, rimoteMessageChannel chan RimoteMessage) {
+// newRimoteMonitor handles monitoring of the connection status
+func newRimoteMonitor(logger *Logger, store *ConfigurationStore) *rimoteMonitor {
+	return &rimoteMonitor{logger: logger, store: store, events: make(chan MonitorEvent)}
+}
 
-	monitor := new(rimoteMonitor)
+// Name of the monitor
+func (monitor *rimoteMonitor) Name() string {
+	return "rimote"
+}
 
-	// Run our logic inside a go routine.
-	go monitor.run(ctx, logger, rimoteMessageChannel)
+// Events returns the RimoteMessage events
+func (monitor *rimoteMonitor) Events() <-chan MonitorEvent {
+	return monitor.events
 }
 
-func (*rimoteMonitor) run(ctx context.Context, logger *Logger, rimoteMessageChannel chan RimoteMessage) {
+// Run the monitor until the context is cancelled
+func (monitor *rimoteMonitor) Run(ctx context.Context) {
 
-	apiEndpoint := "http://localhost:9000/api/rimote/info"
-	defaultTimeout := 30 * time.Second
-	rimoteAPIClient := &http.Client{Timeout: defaultTimeout}
+	logger := monitor.logger
 
 	// Allocate message for the api once.
 	apiMessage := new(RimoteAPIMesssage)
 
 	for {
 
+		changed := monitor.store.Changed()
+		config := monitor.store.Current().Rimote
+
+		apiEndpoint := config.Endpoint
+		defaultTimeout := config.Interval
+		rimoteAPIClient := &http.Client{Timeout: defaultTimeout}
+
 		// Get JSON data from the api.
-		err := getJSON(rimoteAPIClient, apiEndpoint, apiMessage)
+		err := getJSON(ctx, rimoteAPIClient, apiEndpoint, apiMessage)
+
+		// The request is aborted when we're shutting down
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Send empty response when we have api errors
+		rimoteMessage := RimoteMessage{IsConnected: false, HasHardwareID: false}
 
 		// log any errors occured
 		if err != nil {
 			logger.Warningf("Got error while trying to fetch rimote status: %v", err)
-
-			// Send empty response to channel
-			rimoteMessageChannel <- RimoteMessage{IsConnected: false, HasHardwareID: false}
+		} else {
+			rimoteMessage = RimoteMessage{IsConnected: apiMessage.IsConnected, HasHardwareID: true}
 		}
 
-		// Only post to our channel when we don't have any api errors.
-		if err == nil {
-			// Report our status
-			rimoteMessageChannel <- RimoteMessage{IsConnected: apiMessage.IsConnected, HasHardwareID: true}
+		// Report our status
+		if !publishEvent(ctx, monitor.events, monitor.Name(), rimoteMessage) {
+			return
 		}
 
 		// Wait a while before retrying ...
-		time.Sleep(defaultTimeout)
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-time.After(defaultTimeout):
+		}
 	}
 }
 
-func getJSON(httpClient *http.Client, url string, target interface{}) error {
+func getJSON(ctx context.Context, httpClient *http.Client, url string, target interface{}) error {
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
 
-	r, err := httpClient.Get(url)
+	// Abort the request on shutdown
+	r, err := httpClient.Do(request.WithContext(ctx))
 
 	if err != nil {
 		return err
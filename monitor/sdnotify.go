@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SystemdNotifier sends state changes to systemd using the sd_notify protocol
+type SystemdNotifier struct {
+	socket string
+}
+
+// NewSystemdNotifier creates a notifier for $NOTIFY_SOCKET, it does nothing when not started by systemd
+func NewSystemdNotifier() *SystemdNotifier {
+	return &SystemdNotifier{socket: os.Getenv("NOTIFY_SOCKET")}
+}
+
+// Enabled returns true when we're supervised by systemd
+func (notifier *SystemdNotifier) Enabled() bool {
+	return notifier.socket != ""
+}
+
+// Notify sends the state to systemd, for example READY=1 or STATUS=...
+func (notifier *SystemdNotifier) Notify(state string) error {
+
+	if !notifier.Enabled() {
+		return nil
+	}
+
+	// A leading @ is an abstract socket which is handled by the net package
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: notifier.socket, Net: "unixgram"})
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Ready tells systemd we're started
+func (notifier *SystemdNotifier) Ready() error {
+	return notifier.Notify("READY=1")
+}
+
+// Status sends a human readable status to systemd
+func (notifier *SystemdNotifier) Status(status string) error {
+	return notifier.Notify("STATUS=" + strings.Replace(status, "\n", " ", -1))
+}
+
+// Stopping tells systemd we're shutting down
+func (notifier *SystemdNotifier) Stopping() error {
+	return notifier.Notify("STOPPING=1")
+}
+
+// WatchdogInterval returns the watchdog interval configured by systemd for this process
+func WatchdogInterval() (time.Duration, bool) {
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+
+	// The watchdog is meant for another process when the pid does not match
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// RunWatchdog pings the systemd watchdog as long as none of the heartbeats is stalled
+func RunWatchdog(ctx context.Context, logger *Logger, notifier *SystemdNotifier, heartbeats *Heartbeats, store *ConfigurationStore) {
+
+	interval, ok := WatchdogInterval()
+
+	if !ok || !notifier.Enabled() {
+		return
+	}
+
+	if IsDebugMode() {
+		logger.Debugf("Systemd watchdog enabled with interval: %v", interval)
+	}
+
+	go func() {
+
+		// Ping twice per interval like systemd recommends
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		reported := ""
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stalled := heartbeats.Stale(store.Current().Watchdog.StallTimeout)
+
+				if len(stalled) == 0 {
+					if err := notifier.Notify("WATCHDOG=1"); err != nil {
+						logger.Warningf("Could not ping systemd watchdog: %v", err)
+					}
+
+					reported = ""
+					continue
+				}
+
+				// Only log when the stalled subsystems change
+				if names := strings.Join(stalled, ", "); names != reported {
+					logger.Errorf("Withholding systemd watchdog ping, no progress from: %v", names)
+					reported = names
+				}
+			}
+		}
+	}()
+}
+
+// StatusSummary returns a short human readable summary of the status message
+func StatusSummary(msg *Message) string {
+
+	decoded := msg.Decode()
+
+	return fmt.Sprintf("eth0: %v, eth1: %v, wifi: %v, modem: %v/%v, sim: %v, rimote: %v",
+		upDown(decoded.Connection.Eth0),
+		upDown(decoded.Connection.Eth1),
+		decoded.Connection.WifiSignal,
+		decoded.Connection.BroadbandConnType,
+		decoded.Connection.ModemSignal,
+		okFailed(decoded.Connection.SimPinOk),
+		upDown(decoded.Rimote.Connected))
+}
+
+func upDown(value bool) string {
+
+	if value {
+		return "up"
+	}
+
+	return "down"
+}
+
+func okFailed(value bool) string {
+
+	if value {
+		return "ok"
+	}
+
+	return "failed"
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSystemdNotifier(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "rm-monitor")
+
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// Stand-in for the systemd notify socket
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+
+	if err != nil {
+		t.Skipf("Unix datagram sockets are not supported: %v", err)
+	}
+
+	defer conn.Close()
+
+	notifier := &SystemdNotifier{socket: path}
+
+	if err := notifier.Ready(); err != nil {
+		t.Fatalf("Got unexpected error while notifying: %v", err)
+	}
+
+	if err := notifier.Status("eth0: up\nmodem: down"); err != nil {
+		t.Fatalf("Got unexpected error while notifying: %v", err)
+	}
+
+	for _, expected := range []string{"READY=1", "STATUS=eth0: up modem: down"} {
+
+		buf := make([]byte, 256)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+
+		if err != nil {
+			t.Fatalf("Got unexpected error while reading notification: %v", err)
+		}
+
+		if string(buf[:n]) != expected {
+			t.Errorf("Expected notification: %q got: %q", expected, string(buf[:n]))
+		}
+	}
+}
+
+func TestSystemdNotifierDisabled(t *testing.T) {
+
+	notifier := &SystemdNotifier{}
+
+	if notifier.Enabled() {
+		t.Errorf("Expected notifier without socket to be disabled")
+	}
+
+	if err := notifier.Ready(); err != nil {
+		t.Errorf("Expected no error from a disabled notifier got: %v", err)
+	}
+}
+
+func TestHeartbeatsStale(t *testing.T) {
+
+	heartbeats := NewHeartbeats("modem", "ethernet")
+	heartbeats.beats["modem"] = time.Now().Add(-time.Hour)
+
+	stale := heartbeats.Stale(time.Minute)
+
+	if len(stale) != 1 || stale[0] != "modem" {
+		t.Errorf("Expected only modem to be stale got: %v", stale)
+	}
+
+	heartbeats.Beat("modem")
+
+	if stale := heartbeats.Stale(time.Minute); len(stale) != 0 {
+		t.Errorf("Expected no stale heartbeats got: %v", stale)
+	}
+}
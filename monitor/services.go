@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterMonitor("services", func(dependencies MonitorDependencies) Monitor {
+		return NewServicesMonitor(dependencies.Logger, dependencies.Store)
+	})
+}
+
+// systemctlTimeout is the time systemctl gets to report the state of a unit
+const systemctlTimeout = 10 * time.Second
+
+// ServiceStatus structure holding the state of a systemd unit or process
+type ServiceStatus struct {
+	Name     string
+	Kind     string
+	State    string
+	Restarts int
+	Ok       bool
+	Error    string `json:",omitempty"`
+}
+
+// ServicesMessage type, Ok is true when every service runs and is not crash looping
+type ServicesMessage struct {
+	Ok       bool
+	Services []ServiceStatus
+}
+
+// ServicesMonitor checks the systemd units and processes in the configuration
+type ServicesMonitor struct {
+	logger    *Logger
+	store     *ConfigurationStore
+	root      string
+	queryUnit func(ctx context.Context, unit string) (map[string]string, error)
+	history   map[string]*restartHistory
+	processes map[string][]int
+	failed    map[string]bool
+	events    chan MonitorEvent
+}
+
+type restartSample struct {
+	time  time.Time
+	count int
+}
+
+// restartHistory keeps the restart counter of a service over the restart window
+type restartHistory struct {
+	samples []restartSample
+}
+
+// NewServicesMonitor creates the monitor using systemctl and /proc
+func NewServicesMonitor(logger *Logger, store *ConfigurationStore) *ServicesMonitor {
+
+	return &ServicesMonitor{
+		logger:    logger,
+		store:     store,
+		root:      "/",
+		queryUnit: systemctlShow,
+		history:   make(map[string]*restartHistory),
+		processes: make(map[string][]int),
+		failed:    make(map[string]bool),
+		events:    make(chan MonitorEvent),
+	}
+}
+
+// Name of the monitor
+func (monitor *ServicesMonitor) Name() string {
+	return "services"
+}
+
+// Events returns the ServicesMessage events
+func (monitor *ServicesMonitor) Events() <-chan MonitorEvent {
+	return monitor.events
+}
+
+// Run the monitor until the context is cancelled
+func (monitor *ServicesMonitor) Run(ctx context.Context) {
+
+	for {
+
+		changed := monitor.store.Changed()
+		config := monitor.store.Current().Services
+
+		if !publishEvent(ctx, monitor.events, monitor.Name(), monitor.check(ctx, config, time.Now())) {
+			return
+		}
+
+		// Wait a while, a configuration change will trigger a refresh directly.
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-time.After(config.Interval):
+		}
+	}
+}
+
+// check queries every service and logs the services which changed state
+func (monitor *ServicesMonitor) check(ctx context.Context, config ServicesConfiguration, now time.Time) ServicesMessage {
+
+	servicesMessage := ServicesMessage{Ok: true}
+
+	for _, unit := range config.Units {
+		servicesMessage.Services = append(servicesMessage.Services, monitor.checkUnit(ctx, config, unit, now))
+	}
+
+	var pids map[string][]int
+	var err error
+
+	if len(config.Processes) > 0 {
+		pids, err = findProcesses(filepath.Join(monitor.root, "proc"))
+	}
+
+	for _, process := range config.Processes {
+
+		status := ServiceStatus{Name: process, Kind: "process"}
+
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			monitor.checkProcess(config, &status, pids[process], now)
+		}
+
+		servicesMessage.Services = append(servicesMessage.Services, status)
+	}
+
+	for _, status := range servicesMessage.Services {
+
+		key := status.Kind + ":" + status.Name
+
+		if !status.Ok && !monitor.failed[key] {
+			if status.Error != "" {
+				monitor.logger.Errorf("Service: %v cannot be checked: %v", status.Name, status.Error)
+			} else {
+				monitor.logger.Warningf("Service: %v failed state: %v restarts: %v", status.Name, status.State, status.Restarts)
+			}
+		} else if status.Ok && monitor.failed[key] {
+			monitor.logger.Infof("Service: %v is running again", status.Name)
+		}
+
+		monitor.failed[key] = !status.Ok
+		servicesMessage.Ok = servicesMessage.Ok && status.Ok
+	}
+
+	return servicesMessage
+}
+
+func (monitor *ServicesMonitor) checkUnit(ctx context.Context, config ServicesConfiguration, unit string, now time.Time) ServiceStatus {
+
+	status := ServiceStatus{Name: unit, Kind: "unit"}
+
+	queryCtx, cancel := context.WithTimeout(ctx, systemctlTimeout)
+	defer cancel()
+
+	properties, err := monitor.queryUnit(queryCtx, unit)
+
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	status.State = properties["ActiveState"]
+
+	if subState := properties["SubState"]; subState != "" {
+		status.State += "/" + subState
+	}
+
+	// NRestarts is only reported by systemd 235 and newer
+	restarts, _ := strconv.Atoi(properties["NRestarts"])
+	status.Restarts = monitor.restarts("unit:"+unit, restarts, config.RestartWindow, now)
+
+	// A unit waiting to be restarted is activating/auto-restart
+	running := properties["ActiveState"] == "active" || properties["ActiveState"] == "reloading"
+	status.Ok = running && status.Restarts <= config.MaxRestarts
+
+	return status
+}
+
+// checkProcess counts a restart whenever one of the processes is replaced by another pid
+func (monitor *ServicesMonitor) checkProcess(config ServicesConfiguration, status *ServiceStatus, pids []int, now time.Time) {
+
+	key := "process:" + status.Name
+	previous := monitor.processes[key]
+	monitor.processes[key] = pids
+
+	history := monitor.history[key]
+	count := 0
+
+	if history != nil && len(history.samples) > 0 {
+		count = history.samples[len(history.samples)-1].count
+	}
+
+	// A master which recycles its workers keeps running, only a process without any of the previous pids restarted
+	if len(previous) > 0 && len(pids) > 0 && !containsAnyInt(pids, previous) {
+		count++
+	}
+
+	status.Restarts = monitor.restarts(key, count, config.RestartWindow, now)
+
+	if len(pids) == 0 {
+		status.State = "stopped"
+	} else {
+		status.State = "running"
+	}
+
+	status.Ok = len(pids) > 0 && status.Restarts <= config.MaxRestarts
+}
+
+// restarts adds the restart counter of the service and returns the restarts within the window
+func (monitor *ServicesMonitor) restarts(key string, count int, window time.Duration, now time.Time) int {
+
+	history := monitor.history[key]
+
+	// The counter is reset when the unit is restarted by hand
+	if history == nil || (len(history.samples) > 0 && count < history.samples[len(history.samples)-1].count) {
+		history = &restartHistory{}
+		monitor.history[key] = history
+	}
+
+	history.samples = append(history.samples, restartSample{time: now, count: count})
+
+	// Keep the newest sample from before the window as baseline
+	for len(history.samples) > 1 && now.Sub(history.samples[1].time) >= window {
+		history.samples = history.samples[1:]
+	}
+
+	return count - history.samples[0].count
+}
+
+func containsAnyInt(values []int, wanted []int) bool {
+
+	for _, w := range wanted {
+
+		i := sort.SearchInts(values, w)
+
+		if i < len(values) && values[i] == w {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findProcesses returns the sorted pids by process name, the name is the comm or the base name of the executable
+func findProcesses(proc string) (map[string][]int, error) {
+
+	entries, err := ioutil.ReadDir(proc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make(map[string][]int)
+
+	for _, entry := range entries {
+
+		pid, err := strconv.Atoi(entry.Name())
+
+		if err != nil {
+			continue
+		}
+
+		// The process may have exited in the meantime
+		comm, err := ioutil.ReadFile(filepath.Join(proc, entry.Name(), "comm"))
+
+		if err != nil {
+			continue
+		}
+
+		names := []string{strings.TrimSpace(string(comm))}
+
+		// The comm is truncated to 15 characters
+		if cmdline, err := ioutil.ReadFile(filepath.Join(proc, entry.Name(), "cmdline")); err == nil && len(cmdline) > 0 {
+			executable := filepath.Base(string(bytes.SplitN(cmdline, []byte{0}, 2)[0]))
+			if executable != names[0] {
+				names = append(names, executable)
+			}
+		}
+
+		for _, name := range names {
+			processes[name] = append(processes[name], pid)
+		}
+	}
+
+	for name := range processes {
+		sort.Ints(processes[name])
+	}
+
+	return processes, nil
+}
+
+// systemctlShow returns the state properties of the unit
+func systemctlShow(ctx context.Context, unit string) (map[string]string, error) {
+
+	output, err := exec.CommandContext(ctx, "systemctl", "show", unit, "--property=LoadState,ActiveState,SubState,NRestarts").Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("systemctl: %v", err)
+	}
+
+	properties := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+
+	for scanner.Scan() {
+		if parts := strings.SplitN(scanner.Text(), "=", 2); len(parts) == 2 {
+			properties[parts[0]] = parts[1]
+		}
+	}
+
+	if properties["LoadState"] == "not-found" {
+		return nil, fmt.Errorf("unit %v not found", unit)
+	}
+
+	return properties, nil
+}
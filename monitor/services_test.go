@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServicesMonitorUnits(t *testing.T) {
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	units := map[string]map[string]string{
+		"rm-agent.service": {"ActiveState": "active", "SubState": "running", "NRestarts": "0"},
+		"rm-web.service":   {"ActiveState": "failed", "SubState": "failed", "NRestarts": "0"},
+	}
+
+	monitor := NewServicesMonitor(logger, NewConfigurationStore("", DefaultConfiguration()))
+	monitor.queryUnit = func(ctx context.Context, unit string) (map[string]string, error) {
+		if properties, ok := units[unit]; ok {
+			return properties, nil
+		}
+		return nil, errors.New("unit not found")
+	}
+
+	config := DefaultConfiguration().Services
+	config.Units = []string{"rm-agent.service"}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if servicesMessage := monitor.check(context.Background(), config, start); !servicesMessage.Ok {
+		t.Errorf("Expected the active unit to be ok got: %+v", servicesMessage)
+	}
+
+	// A failed or unknown unit fails the software status
+	for _, unit := range []string{"rm-web.service", "missing.service"} {
+
+		failing := config
+		failing.Units = []string{"rm-agent.service", unit}
+
+		if servicesMessage := monitor.check(context.Background(), failing, start); servicesMessage.Ok {
+			t.Errorf("Expected: %v to fail got: %+v", unit, servicesMessage)
+		}
+	}
+
+	// The restarts within the window are counted, not the restarts since boot
+	tests := []struct {
+		offset   time.Duration
+		state    string
+		restarts string
+		expected int
+		ok       bool
+	}{
+		{offset: time.Minute, state: "active", restarts: "2", expected: 2, ok: true},
+		{offset: 2 * time.Minute, state: "activating", restarts: "3", expected: 3, ok: false},
+		{offset: 3 * time.Minute, state: "active", restarts: "5", expected: 5, ok: false},
+		{offset: 12 * time.Minute, state: "active", restarts: "5", expected: 2, ok: true},
+		{offset: 14 * time.Minute, state: "active", restarts: "5", expected: 0, ok: true},
+		{offset: 15 * time.Minute, state: "active", restarts: "6", expected: 1, ok: true},
+		// The counter is reset by restarting the unit by hand
+		{offset: 16 * time.Minute, state: "active", restarts: "0", expected: 0, ok: true},
+	}
+
+	for _, tt := range tests {
+
+		units["rm-agent.service"] = map[string]string{"ActiveState": tt.state, "NRestarts": tt.restarts}
+		servicesMessage := monitor.check(context.Background(), config, start.Add(tt.offset))
+
+		if servicesMessage.Ok != tt.ok || servicesMessage.Services[0].Restarts != tt.expected {
+			t.Errorf("Expected ok: %v with: %v restarts after: %v got: %+v", tt.ok, tt.expected, tt.offset, servicesMessage)
+		}
+	}
+}
+
+func TestServicesMonitorProcesses(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "rm-monitor")
+
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(root)
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	writeSysfsFiles(t, root, map[string]string{
+		"proc/1/comm":    "systemd\n",
+		"proc/1/cmdline": "/sbin/init\x00",
+		// The comm is truncated, the executable in the cmdline is not
+		"proc/100/comm":    "rm-broadband-ag\n",
+		"proc/100/cmdline": "/usr/bin/rm-broadband-agent\x00--config\x00/etc/rm.yml\x00",
+		"proc/200/comm":    "rm-web\n",
+		"proc/201/comm":    "rm-web\n",
+		"proc/mounts":      "/dev/root / ext4 ro 0 0\n",
+	})
+
+	processes, err := findProcesses(filepath.Join(root, "proc"))
+
+	if err != nil {
+		t.Fatalf("Cannot find processes: %v", err)
+	}
+
+	expected := map[string][]int{
+		"systemd":            {1},
+		"init":               {1},
+		"rm-broadband-ag":    {100},
+		"rm-broadband-agent": {100},
+		"rm-web":             {200, 201},
+	}
+
+	if !reflect.DeepEqual(processes, expected) {
+		t.Errorf("Expected: %v got: %v", expected, processes)
+	}
+
+	monitor := NewServicesMonitor(logger, NewConfigurationStore("", DefaultConfiguration()))
+	monitor.root = root
+
+	config := DefaultConfiguration().Services
+	config.Processes = []string{"rm-broadband-agent", "rm-web"}
+	config.MaxRestarts = 1
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if servicesMessage := monitor.check(context.Background(), config, start); !servicesMessage.Ok {
+		t.Errorf("Expected the processes to be ok got: %+v", servicesMessage)
+	}
+
+	// A second or recycled worker is not a restart, a replaced pid is
+	steps := []struct {
+		remove   []string
+		files    map[string]string
+		restarts int
+		ok       bool
+	}{
+		{files: map[string]string{"proc/202/comm": "rm-web"}, restarts: 0, ok: true},
+		{remove: []string{"proc/201"}, files: map[string]string{"proc/203/comm": "rm-web"}, restarts: 0, ok: true},
+		{remove: []string{"proc/100"}, files: map[string]string{"proc/101/comm": "rm-broadband-agent"}, restarts: 1, ok: true},
+		{remove: []string{"proc/101"}, files: map[string]string{"proc/102/comm": "rm-broadband-agent"}, restarts: 2, ok: false},
+		{remove: []string{"proc/102"}, restarts: 2, ok: false},
+	}
+
+	for i, step := range steps {
+
+		for _, name := range step.remove {
+			os.RemoveAll(filepath.Join(root, name))
+		}
+
+		writeSysfsFiles(t, root, step.files)
+
+		servicesMessage := monitor.check(context.Background(), config, start.Add(time.Duration(i+1)*time.Minute))
+
+		if servicesMessage.Ok != step.ok || servicesMessage.Services[0].Restarts != step.restarts || servicesMessage.Services[1].Restarts != 0 {
+			t.Errorf("Expected ok: %v with: %v restarts at step: %v got: %+v", step.ok, step.restarts, i, servicesMessage)
+		}
+	}
+
+	if state := monitor.check(context.Background(), config, start.Add(time.Hour)).Services[0].State; state != "stopped" {
+		t.Errorf("Expected the agent to be stopped got: %v", state)
+	}
+}
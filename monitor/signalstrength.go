@@ -1,5 +1,10 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+)
+
 // SignalStrength type
 type SignalStrength uint
 
@@ -15,3 +20,50 @@ const (
 	// GoodSignal strength
 	GoodSignal SignalStrength = 4
 )
+
+var signalStrengthNames = map[SignalStrength]string{
+	ErrorSignal: "error",
+	NoSignal:    "none",
+	WeakSignal:  "weak",
+	FairSignal:  "fair",
+	GoodSignal:  "good",
+}
+
+func (signalStrength SignalStrength) String() string {
+
+	if name, ok := signalStrengthNames[signalStrength]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", uint(signalStrength))
+}
+
+// ParseSignalStrength parses the name of a signal strength
+func ParseSignalStrength(name string) (SignalStrength, error) {
+
+	for signalStrength, signalName := range signalStrengthNames {
+		if signalName == strings.ToLower(name) {
+			return signalStrength, nil
+		}
+	}
+
+	return ErrorSignal, fmt.Errorf("unknown signal strength: %v", name)
+}
+
+// MarshalText encodes the signal strength by name
+func (signalStrength SignalStrength) MarshalText() ([]byte, error) {
+	return []byte(signalStrength.String()), nil
+}
+
+// UnmarshalText decodes the signal strength by name
+func (signalStrength *SignalStrength) UnmarshalText(text []byte) error {
+
+	parsed, err := ParseSignalStrength(string(text))
+
+	if err != nil {
+		return err
+	}
+
+	*signalStrength = parsed
+	return nil
+}
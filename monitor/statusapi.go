@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RecordedValue structure holding a value and the time it was received
+type RecordedValue struct {
+	Received time.Time   `json:"received"`
+	Value    interface{} `json:"value"`
+}
+
+// MessageSnapshot structure
+type MessageSnapshot struct {
+	Sent    time.Time      `json:"sent"`
+	Raw     string         `json:"raw"`
+	Decoded DecodedMessage `json:"decoded"`
+}
+
+// MonitorStatus structure holding the supervision counters of a monitor
+type MonitorStatus struct {
+	Restarts  int       `json:"restarts"`
+	Crashes   int       `json:"crashes"`
+	LastCrash time.Time `json:"lastCrash,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// ReceiverStatus structure holding the liveness of a status receiver with feedback
+type ReceiverStatus struct {
+	Alive    bool      `json:"alive"`
+	LastAck  time.Time `json:"lastAck,omitempty"`
+	Sequence uint32    `json:"sequence"`
+}
+
+// StatusSnapshot structure returned by the status api
+type StatusSnapshot struct {
+	Message   *MessageSnapshot          `json:"message"`
+	Ethernet  *RecordedValue            `json:"ethernet"`
+	Modem     *RecordedValue            `json:"modem"`
+	Rimote    *RecordedValue            `json:"rimote"`
+	HostInfo  *RecordedValue            `json:"hostInfo"`
+	Vcc       *RecordedValue            `json:"vcc"`
+	Storage   *RecordedValue            `json:"storage"`
+	Services  *RecordedValue            `json:"services"`
+	Monitors  map[string]MonitorStatus  `json:"monitors"`
+	Receivers map[string]ReceiverStatus `json:"receivers,omitempty"`
+}
+
+// StatusRecorder keeps the last known state of every monitor
+type StatusRecorder struct {
+	mutex    sync.RWMutex
+	snapshot StatusSnapshot
+}
+
+// NewStatusRecorder creates an empty recorder
+func NewStatusRecorder() *StatusRecorder {
+	return &StatusRecorder{}
+}
+
+// RecordMessage records the status message which is sent
+func (recorder *StatusRecorder) RecordMessage(msg *Message) {
+
+	copied := &Message{Data: msg.Data}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.snapshot.Message = &MessageSnapshot{
+		Sent:    time.Now(),
+		Raw:     hex.EncodeToString(copied.Data[:]),
+		Decoded: copied.Decode(),
+	}
+}
+
+// RecordEthernet records the last ethernet message
+func (recorder *StatusRecorder) RecordEthernet(ethernetMessage EthernetMessage) {
+	recorder.record(&recorder.snapshot.Ethernet, ethernetMessage)
+}
+
+// RecordModem records the last modem status message
+func (recorder *StatusRecorder) RecordModem(modemStatusMessage ModemStatusMessage) {
+	recorder.record(&recorder.snapshot.Modem, modemStatusMessage)
+}
+
+// RecordRimote records the last rimote message
+func (recorder *StatusRecorder) RecordRimote(rimoteMessage RimoteMessage) {
+	recorder.record(&recorder.snapshot.Rimote, rimoteMessage)
+}
+
+// RecordHostInfo records the last host info
+func (recorder *StatusRecorder) RecordHostInfo(hostInfo HostInfo) {
+	recorder.record(&recorder.snapshot.HostInfo, hostInfo)
+}
+
+// RecordVcc records the last supply voltages
+func (recorder *StatusRecorder) RecordVcc(vccMessage VccMessage) {
+	recorder.record(&recorder.snapshot.Vcc, vccMessage)
+}
+
+// RecordStorage records the last storage checks
+func (recorder *StatusRecorder) RecordStorage(storageMessage StorageMessage) {
+	recorder.record(&recorder.snapshot.Storage, storageMessage)
+}
+
+// RecordServices records the last state of the services
+func (recorder *StatusRecorder) RecordServices(servicesMessage ServicesMessage) {
+	recorder.record(&recorder.snapshot.Services, servicesMessage)
+}
+
+// RecordReceivers records the liveness of the status receivers, the map must not be modified afterwards
+func (recorder *StatusRecorder) RecordReceivers(receivers map[string]ReceiverStatus) {
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.snapshot.Receivers = receivers
+}
+
+// RecordMonitorRestart counts a restart of a stalled monitor
+func (recorder *StatusRecorder) RecordMonitorRestart(name string) {
+	recorder.updateMonitor(name, func(status *MonitorStatus) {
+		status.Restarts++
+	})
+}
+
+// RecordMonitorCrash counts a recovered panic of a monitor
+func (recorder *StatusRecorder) RecordMonitorCrash(name string, reason string) {
+	recorder.updateMonitor(name, func(status *MonitorStatus) {
+		status.Crashes++
+		status.LastCrash = time.Now()
+		status.Reason = reason
+	})
+}
+
+func (recorder *StatusRecorder) updateMonitor(name string, fn func(status *MonitorStatus)) {
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	// The map is replaced so snapshots handed out before are never modified
+	monitors := make(map[string]MonitorStatus, len(recorder.snapshot.Monitors)+1)
+
+	for key, value := range recorder.snapshot.Monitors {
+		monitors[key] = value
+	}
+
+	status := monitors[name]
+	fn(&status)
+	monitors[name] = status
+
+	recorder.snapshot.Monitors = monitors
+}
+
+func (recorder *StatusRecorder) record(target **RecordedValue, value interface{}) {
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	*target = &RecordedValue{Received: time.Now(), Value: value}
+}
+
+// Snapshot returns a copy of the recorded state
+func (recorder *StatusRecorder) Snapshot() StatusSnapshot {
+
+	recorder.mutex.RLock()
+	defer recorder.mutex.RUnlock()
+
+	return recorder.snapshot
+}
+
+// NewStatusHandler creates the http handler serving the read-only status api
+func NewStatusHandler(recorder *StatusRecorder) *http.ServeMux {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(recorder.Snapshot())
+	})
+
+	return mux
+}
+
+// StartStatusServer serves the status api until the context is cancelled
+func StartStatusServer(ctx context.Context, logger *Logger, config APIConfiguration, handler http.Handler) error {
+
+	listener, err := net.Listen("tcp", config.Address)
+
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: handler, ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("Status api stopped: %v", err)
+		}
+	}()
+
+	if IsDebugMode() {
+		logger.Debugf("Status api listening @ http://%v/status", listener.Addr())
+	}
+
+	return nil
+}
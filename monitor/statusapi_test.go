@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusHandler(t *testing.T) {
+
+	recorder := NewStatusRecorder()
+
+	msg := NewMessage()
+	msg.ConnectionStatus().SetEth0Status(true)
+	msg.ConnectionStatus().SetModemSignal(FairSignal)
+	recorder.RecordMessage(msg)
+	recorder.RecordRimote(RimoteMessage{IsConnected: true, HasHardwareID: true})
+
+	server := httptest.NewServer(NewStatusHandler(recorder))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/status")
+
+	if err != nil {
+		t.Fatalf("Got unexpected error requesting status: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	var status struct {
+		Message struct {
+			Raw     string
+			Decoded struct {
+				Connection struct {
+					Eth0        bool
+					ModemSignal string
+				}
+			}
+		}
+		Rimote struct {
+			Value RimoteMessage
+		}
+		Modem *RecordedValue
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		t.Fatalf("Got unexpected error decoding status: %v", err)
+	}
+
+	if status.Message.Raw != "0000004200000000" {
+		t.Errorf("Expected raw message: 0000004200000000 got: %v", status.Message.Raw)
+	}
+
+	if !status.Message.Decoded.Connection.Eth0 {
+		t.Errorf("Expected eth0 to be connected")
+	}
+
+	if status.Message.Decoded.Connection.ModemSignal != "fair" {
+		t.Errorf("Expected modem signal: fair got: %v", status.Message.Decoded.Connection.ModemSignal)
+	}
+
+	if !status.Rimote.Value.IsConnected {
+		t.Errorf("Expected rimote to be connected")
+	}
+
+	if status.Modem != nil {
+		t.Errorf("Expected no modem value before the first modem message got: %+v", status.Modem)
+	}
+
+	response, err = http.Post(server.URL+"/status", "application/json", nil)
+
+	if err != nil {
+		t.Fatalf("Got unexpected error posting status: %v", err)
+	}
+
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code: %v got: %v", http.StatusMethodNotAllowed, response.StatusCode)
+	}
+}
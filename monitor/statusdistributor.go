@@ -2,35 +2,150 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
 )
 
-var udpConnection = CreateUDPConnection()
-
 // UDPConnection struct
 type UDPConnection struct {
 	Address    *net.UDPAddr
+	Target     StatusTarget
 	connection net.Conn
+	closed     chan struct{}
+	failing    bool
+	feedback   *receiverFeedback
+	alive      bool
+}
+
+// receiverFeedback holds the last acknowledgement of a receiver
+type receiverFeedback struct {
+	mutex    sync.Mutex
+	lastSeen time.Time
+	lastAck  time.Time
+	sequence uint32
 }
 
 // CreateUDPConnection creates udp connection
-func CreateUDPConnection() *UDPConnection {
+func CreateUDPConnection(target StatusTarget) (*UDPConnection, error) {
+
+	addr, err := net.ResolveUDPAddr("udp", target.Address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	udpConnection := &UDPConnection{Address: addr, Target: target}
+
+	// The receiver gets a full feedback timeout to acknowledge
+	if target.Feedback {
+		udpConnection.feedback = &receiverFeedback{lastSeen: time.Now()}
+		udpConnection.alive = true
+	}
+
+	return udpConnection, nil
+}
+
+// Close closes the active connection, the next call will reconnect
+func (udpConnection *UDPConnection) Close() {
+
+	// Tell the receiving goroutine the read error is caused by us
+	if udpConnection.closed != nil {
+		close(udpConnection.closed)
+		udpConnection.closed = nil
+	}
+
+	if udpConnection.connection != nil {
+		udpConnection.connection.Close()
+		udpConnection.connection = nil
+	}
+}
 
-	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:9876")
-	return &UDPConnection{Address: addr}
+// receive reads the acknowledgements of the receiver until the connection is closed
+func (udpConnection *UDPConnection) receive(udp net.Conn, closed <-chan struct{}) {
+
+	buffer := make([]byte, 512)
+
+	for {
+		n, err := udp.Read(buffer)
+
+		if err != nil {
+			select {
+			case <-closed:
+				return
+			default:
+				// A datagram refused by the receiver is reported on the next read
+				continue
+			}
+		}
+
+		sequence, err := DecodeStatusAck(buffer[:n])
+
+		if err != nil {
+			continue
+		}
+
+		metrics.StatusAcks.Inc(udpConnection.Target.Address)
+
+		feedback := udpConnection.feedback
+		feedback.mutex.Lock()
+		feedback.lastSeen = time.Now()
+		feedback.lastAck = feedback.lastSeen
+		feedback.sequence = sequence
+		feedback.mutex.Unlock()
+	}
+}
+
+// Status returns the liveness of the receiver, it's alive when it acknowledged within the timeout
+func (feedback *receiverFeedback) Status(timeout time.Duration) ReceiverStatus {
+
+	feedback.mutex.Lock()
+	defer feedback.mutex.Unlock()
+
+	return ReceiverStatus{
+		Alive:    time.Since(feedback.lastSeen) <= timeout,
+		LastAck:  feedback.lastAck,
+		Sequence: feedback.sequence,
+	}
+}
+
+func (udpConnection *UDPConnection) dial() (net.Conn, error) {
+
+	dialer := net.Dialer{}
+
+	if udpConnection.Address.IP.IsMulticast() {
+		control, err := multicastControl(udpConnection.Target, udpConnection.Address)
+
+		if err != nil {
+			return nil, err
+		}
+
+		dialer.Control = control
+	}
+
+	return dialer.Dial("udp", udpConnection.Address.String())
 }
 
 // Execute UDP call
 func (udpConnection *UDPConnection) Execute(logger *Logger, f func(net.Conn) error) error {
 
 	if udpConnection.connection == nil {
-		udp, err := net.DialUDP("udp", nil, udpConnection.Address)
+		udp, err := udpConnection.dial()
 		if err == nil {
 			udpConnection.connection = udp
+
+			if udpConnection.feedback != nil {
+				udpConnection.closed = make(chan struct{})
+				go udpConnection.receive(udp, udpConnection.closed)
+			}
+
 			err = f(udp)
 			if err != nil {
-				udpConnection.connection.Close()
-				udpConnection.connection = nil
+				udpConnection.Close()
 			}
 
 			return err
@@ -43,8 +158,7 @@ func (udpConnection *UDPConnection) Execute(logger *Logger, f func(net.Conn) err
 
 	err := f(udp)
 	if err != nil {
-		udpConnection.connection.Close()
-		udpConnection.connection = nil
+		udpConnection.Close()
 
 		logger.DebugF("Cannot send distributed status message: %v", err)
 
@@ -57,15 +171,238 @@ func (udpConnection *UDPConnection) Execute(logger *Logger, f func(net.Conn) err
 }
 
 // SendMessage send an udp message
-func SendMessage(logger *Logger, message [8]byte) error {
+func SendMessage(logger *Logger, udpConnection *UDPConnection, message [8]byte) error {
+	return SendDatagram(logger, udpConnection, message[:])
+}
+
+// SendDatagram sends the data as a single udp datagram
+func SendDatagram(logger *Logger, udpConnection *UDPConnection, data []byte) error {
 
-	return udpConnection.Execute(logger, func(udp net.Conn) error {
+	err := udpConnection.Execute(logger, func(udp net.Conn) error {
 
-		n, err := udp.Write(message[:])
-		if n != 8 && err == nil {
+		n, err := udp.Write(data)
+		if n != len(data) && err == nil {
 			return errors.New("Invallid data length")
 		}
 
 		return err
 	})
+
+	if err != nil {
+		metrics.StatusSendErrors.Inc(udpConnection.Target.Address)
+	}
+
+	return err
+}
+
+// StatusDistributor sends the status to every configured target
+type StatusDistributor struct {
+	connections     []*UDPConnection
+	interval        time.Duration
+	feedbackTimeout time.Duration
+	encoder         *StatusEncoder
+}
+
+// NewStatusDistributor creates the connections to the targets of the configuration
+func NewStatusDistributor(config StatusConfiguration) (*StatusDistributor, error) {
+
+	distributor := &StatusDistributor{encoder: NewStatusEncoder()}
+
+	if err := distributor.Reconfigure(config); err != nil {
+		return nil, err
+	}
+
+	return distributor, nil
+}
+
+// Reconfigure replaces the targets, the active targets are kept when the configuration is invalid
+func (distributor *StatusDistributor) Reconfigure(config StatusConfiguration) error {
+
+	connections := []*UDPConnection{}
+
+	for _, target := range config.StatusTargets() {
+
+		connection, err := CreateUDPConnection(target)
+
+		if err != nil {
+			return fmt.Errorf("status target %v: %v", target.Address, err)
+		}
+
+		// Keep unchanged targets connected
+		for _, existing := range distributor.connections {
+			if reflect.DeepEqual(existing.Target, target) && existing.Address.String() == connection.Address.String() {
+				connection = existing
+				break
+			}
+		}
+
+		connections = append(connections, connection)
+	}
+
+	for _, existing := range distributor.connections {
+		if !containsUDPConnection(connections, existing) {
+			existing.Close()
+		}
+	}
+
+	distributor.connections = connections
+	distributor.interval = config.Interval
+	distributor.feedbackTimeout = config.FeedbackTimeout
+
+	return nil
+}
+
+func containsUDPConnection(connections []*UDPConnection, connection *UDPConnection) bool {
+
+	for _, c := range connections {
+		if c == connection {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Targets returns the addresses of the targets
+func (distributor *StatusDistributor) Targets() []string {
+
+	targets := make([]string, len(distributor.connections))
+
+	for i, connection := range distributor.connections {
+		targets[i] = connection.Address.String()
+	}
+
+	return targets
+}
+
+// Send sends the message to every target in its formats, a failing target does not affect the others
+func (distributor *StatusDistributor) Send(logger *Logger, msg *Message) error {
+
+	// Encode once so every target gets the same sequence number
+	var v2 []byte
+
+	datagram := func(format string) []byte {
+
+		if format == StatusFormatLegacy {
+			return msg.Data[:]
+		}
+
+		if v2 == nil {
+			v2 = distributor.encoder.Encode(msg, distributor.interval)
+		}
+
+		return v2
+	}
+
+	var firstErr error
+
+	for _, connection := range distributor.connections {
+
+		var err error
+
+		for _, format := range connection.Target.Formats {
+			if err = SendDatagram(logger, connection, datagram(format)); err != nil {
+				break
+			}
+		}
+
+		// Only log changes to prevent flooding the log
+		if err != nil && !connection.failing {
+			logger.Errorf("could not send status message to: %v error: %v", connection.Address, err)
+		} else if err == nil && connection.failing {
+			logger.Infof("Sending status messages to: %v again", connection.Address)
+		}
+
+		connection.failing = err != nil
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		if connection.feedback != nil {
+			distributor.checkFeedback(logger, connection)
+		}
+	}
+
+	return firstErr
+}
+
+// checkFeedback logs when a receiver stops or starts acknowledging again
+func (distributor *StatusDistributor) checkFeedback(logger *Logger, connection *UDPConnection) {
+
+	status := connection.feedback.Status(distributor.feedbackTimeout)
+	metrics.ReceiverAlive.SetBool(status.Alive, connection.Target.Address)
+
+	if !status.Alive && connection.alive {
+		if status.LastAck.IsZero() {
+			logger.Warningf("Status receiver: %v did not acknowledge within: %v", connection.Address, distributor.feedbackTimeout)
+		} else {
+			logger.Warningf("Status receiver: %v stopped acknowledging since: %v", connection.Address, status.LastAck.Format(time.RFC3339))
+		}
+	} else if status.Alive && !connection.alive {
+		logger.Infof("Status receiver: %v acknowledges again", connection.Address)
+	}
+
+	connection.alive = status.Alive
+}
+
+// Receivers returns the liveness of every target with feedback
+func (distributor *StatusDistributor) Receivers() map[string]ReceiverStatus {
+
+	receivers := make(map[string]ReceiverStatus)
+
+	for _, connection := range distributor.connections {
+		if connection.feedback != nil {
+			receivers[connection.Target.Address] = connection.feedback.Status(distributor.feedbackTimeout)
+		}
+	}
+
+	return receivers
+}
+
+// Close closes every connection
+func (distributor *StatusDistributor) Close() {
+
+	for _, connection := range distributor.connections {
+		connection.Close()
+	}
+}
+
+// WriteStatusFile writes the last status message so other commands can read it
+func WriteStatusFile(path string, message [8]byte) error {
+
+	// Write to a temporary file first so readers never see a partial message
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, message[:], 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// ReadStatusFile reads the last status message and the time it was written
+func ReadStatusFile(path string) (*Message, time.Time, error) {
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	msg := NewMessage()
+
+	if len(data) != len(msg.Data) {
+		return nil, time.Time{}, fmt.Errorf("invallid status file length: %v", len(data))
+	}
+
+	copy(msg.Data[:], data)
+
+	return msg, info.ModTime(), nil
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listenStatusTarget(t *testing.T) *net.UDPConn {
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+
+	return conn
+}
+
+func readStatusDatagram(t *testing.T, conn *net.UDPConn) []byte {
+
+	buffer := make([]byte, 512)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	n, err := conn.Read(buffer)
+
+	if err != nil {
+		t.Fatalf("Cannot read status datagram: %v", err)
+	}
+
+	return buffer[:n]
+}
+
+func TestStatusDistributorSendsToEveryTarget(t *testing.T) {
+
+	hmi := listenStatusTarget(t)
+	defer hmi.Close()
+
+	logger := listenStatusTarget(t)
+	defer logger.Close()
+
+	config := DefaultConfiguration().Status
+	config.Address = hmi.LocalAddr().String()
+	config.Targets = []StatusTarget{{Address: logger.LocalAddr().String(), Formats: []string{StatusFormatV2}}}
+
+	distributor, err := NewStatusDistributor(config)
+
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	defer distributor.Close()
+
+	log, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	msg := NewMessage()
+	msg.RimoteStatus().SetRimoteConnected(true)
+
+	if err := distributor.Send(log, msg); err != nil {
+		t.Fatalf("Got unexpected error while sending: %v", err)
+	}
+
+	if data := readStatusDatagram(t, hmi); !reflect.DeepEqual(data, msg.Data[:]) {
+		t.Errorf("Expected legacy frame: %x got: %x", msg.Data, data)
+	}
+
+	datagram, err := DecodeStatusDatagram(readStatusDatagram(t, logger))
+
+	if err != nil || datagram.Version != 2 || datagram.Message.Data != msg.Data {
+		t.Errorf("Expected v2 datagram with: %x got: %+v error: %v", msg.Data, datagram, err)
+	}
+}
+
+func TestStatusTargets(t *testing.T) {
+
+	config := DefaultConfiguration().Status
+	config.Formats = []string{StatusFormatLegacy, StatusFormatV2}
+	config.Targets = []StatusTarget{
+		{Address: "239.1.2.3:9876", TTL: 2, Interface: "eth1"},
+		{Address: "[ff02::1]:9876", Formats: []string{StatusFormatV2}},
+	}
+
+	expected := []StatusTarget{
+		{Address: "127.0.0.1:9876", Formats: []string{StatusFormatLegacy, StatusFormatV2}},
+		{Address: "239.1.2.3:9876", TTL: 2, Interface: "eth1", Formats: []string{StatusFormatLegacy, StatusFormatV2}},
+		{Address: "[ff02::1]:9876", Formats: []string{StatusFormatV2}},
+	}
+
+	if targets := config.StatusTargets(); !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected targets: %+v got: %+v", expected, targets)
+	}
+}
+
+func TestUDPConnectionDialsLinkLocalMulticast(t *testing.T) {
+
+	interfaces, err := net.Interfaces()
+
+	if err != nil {
+		t.Fatalf("Cannot list interfaces: %v", err)
+	}
+
+	for _, ifi := range interfaces {
+
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+
+		// The group has no zone, the interface must be applied before connecting
+		connection, err := CreateUDPConnection(StatusTarget{Address: "[ff02::42]:9876", TTL: 1, Interface: ifi.Name})
+
+		if err != nil {
+			t.Fatalf("Cannot create connection: %v", err)
+		}
+
+		udp, err := connection.dial()
+
+		if err != nil {
+			t.Fatalf("Cannot dial link-local multicast group @ %v: %v", ifi.Name, err)
+		}
+
+		udp.Close()
+		return
+	}
+
+	t.Skip("No multicast interface available")
+}
+
+func TestStatusDistributorReceiverFeedback(t *testing.T) {
+
+	receiver := listenStatusTarget(t)
+	defer receiver.Close()
+
+	config := DefaultConfiguration().Status
+	config.Address = receiver.LocalAddr().String()
+	config.Formats = []string{StatusFormatV2}
+	config.Feedback = true
+	config.FeedbackTimeout = 200 * time.Millisecond
+
+	distributor, err := NewStatusDistributor(config)
+
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	defer distributor.Close()
+
+	log, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	msg := NewMessage()
+
+	for sequence := uint32(0); sequence < 2; sequence++ {
+
+		if err := distributor.Send(log, msg); err != nil {
+			t.Fatalf("Got unexpected error while sending: %v", err)
+		}
+
+		buffer := make([]byte, 512)
+		receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, from, err := receiver.ReadFrom(buffer)
+
+		if err != nil {
+			t.Fatalf("Cannot read status datagram: %v", err)
+		}
+
+		datagram, err := DecodeStatusDatagram(buffer[:n])
+
+		if err != nil {
+			t.Fatalf("Got unexpected error while decoding: %v", err)
+		}
+
+		receiver.WriteTo(EncodeStatusAck(datagram.Sequence, 0), from)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for distributor.Receivers()[config.Address].Sequence != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if status := distributor.Receivers()[config.Address]; !status.Alive || status.LastAck.IsZero() || status.Sequence != 1 {
+		t.Errorf("Expected an alive receiver which acknowledged sequence 1 got: %+v", status)
+	}
+
+	// Without acknowledgements the receiver is gone after the timeout
+	time.Sleep(config.FeedbackTimeout + 50*time.Millisecond)
+	distributor.Send(log, msg)
+
+	if status := distributor.Receivers()[config.Address]; status.Alive {
+		t.Errorf("Expected the receiver to be gone got: %+v", status)
+	}
+
+	if alive := metrics.ReceiverAlive.Value(config.Address); alive != 0 {
+		t.Errorf("Expected the receiver alive metric to be 0 got: %v", alive)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// Status datagram v2 layout, all numbers are big endian:
+//
+//	magic      2 bytes "RM"
+//	version    1 byte  2
+//	flags      1 byte  0x01 acknowledgement, the other bits are reserved
+//	length     2 bytes length of the tlv payload
+//	sequence   4 bytes incremented for every datagram
+//	timestamp  8 bytes milliseconds since the sender started (monotonic)
+//	payload    tlv records: type 1 byte, length 1 byte, value
+//	crc        4 bytes crc32 (IEEE) of everything before
+//
+// Receivers must skip unknown tlv types so new records can be added without a new version.
+//
+// A receiver can send an acknowledgement back to the source address of the datagram: a header with
+// the ack flag, the sequence of the last datagram received and no payload. The same acknowledgement
+// can be sent as keepalive.
+const (
+	// StatusFormatLegacy is the bare 8 byte status message
+	StatusFormatLegacy = "legacy"
+	// StatusFormatV2 is the self-describing datagram
+	StatusFormatV2 = "v2"
+
+	// StatusFlagAck marks an acknowledgement sent back by a receiver
+	StatusFlagAck byte = 0x01
+
+	statusMagic         = "RM"
+	statusVersion2 byte = 2
+	statusHeaderSize    = 18
+	statusCrcSize       = 4
+)
+
+// StatusTLVType type
+type StatusTLVType byte
+
+const (
+	// StatusTLVMessage holds the 8 byte status message
+	StatusTLVMessage StatusTLVType = 1
+	// StatusTLVInterval holds the send interval in milliseconds as 4 bytes
+	StatusTLVInterval StatusTLVType = 2
+)
+
+var (
+	errStatusTooShort = errors.New("status datagram too short")
+	errStatusMagic    = errors.New("status datagram has an invalid magic")
+	errStatusCrc      = errors.New("status datagram has an invalid crc")
+)
+
+// StatusDatagram structure, a legacy frame is decoded as version 1 without sequence and timestamp
+type StatusDatagram struct {
+	Version   byte
+	Sequence  uint32
+	Timestamp time.Duration
+	Interval  time.Duration
+	Message   Message
+}
+
+// StatusEncoder encodes v2 datagrams, the sequence and timestamp continue over every datagram
+type StatusEncoder struct {
+	started  time.Time
+	sequence uint32
+}
+
+// NewStatusEncoder creates an encoder starting at sequence 0
+func NewStatusEncoder() *StatusEncoder {
+	return &StatusEncoder{started: time.Now()}
+}
+
+// Encode encodes the message as v2 datagram
+func (encoder *StatusEncoder) Encode(msg *Message, interval time.Duration) []byte {
+
+	payload := make([]byte, 0, 16)
+	payload = appendStatusTLV(payload, StatusTLVMessage, msg.Data[:])
+
+	intervalValue := make([]byte, 4)
+	binary.BigEndian.PutUint32(intervalValue, uint32(interval/time.Millisecond))
+	payload = appendStatusTLV(payload, StatusTLVInterval, intervalValue)
+
+	// time.Since uses the monotonic clock
+	data := encodeStatusDatagram(0, encoder.sequence, time.Since(encoder.started), payload)
+	encoder.sequence++
+
+	return data
+}
+
+// EncodeStatusAck encodes the acknowledgement of the datagram with the sequence
+func EncodeStatusAck(sequence uint32, timestamp time.Duration) []byte {
+	return encodeStatusDatagram(StatusFlagAck, sequence, timestamp, nil)
+}
+
+func encodeStatusDatagram(flags byte, sequence uint32, timestamp time.Duration, payload []byte) []byte {
+
+	data := make([]byte, statusHeaderSize, statusHeaderSize+len(payload)+statusCrcSize)
+	copy(data[0:2], statusMagic)
+	data[2] = statusVersion2
+	data[3] = flags
+	binary.BigEndian.PutUint16(data[4:6], uint16(len(payload)))
+	binary.BigEndian.PutUint32(data[6:10], sequence)
+	binary.BigEndian.PutUint64(data[10:18], uint64(timestamp/time.Millisecond))
+
+	data = append(data, payload...)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-statusCrcSize:], crc32.ChecksumIEEE(data[:len(data)-statusCrcSize]))
+
+	return data
+}
+
+func appendStatusTLV(payload []byte, tlvType StatusTLVType, value []byte) []byte {
+	payload = append(payload, byte(tlvType), byte(len(value)))
+	return append(payload, value...)
+}
+
+// DecodeStatusDatagram decodes a legacy 8 byte frame or a v2 datagram
+func DecodeStatusDatagram(data []byte) (*StatusDatagram, error) {
+
+	datagram := &StatusDatagram{}
+
+	// Legacy frames have no header at all
+	if len(data) == len(datagram.Message.Data) {
+		datagram.Version = 1
+		copy(datagram.Message.Data[:], data)
+		return datagram, nil
+	}
+
+	payload, err := decodeStatusHeader(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if data[3]&StatusFlagAck != 0 {
+		return nil, errors.New("status datagram is an acknowledgement")
+	}
+
+	datagram.Version = data[2]
+	datagram.Sequence = binary.BigEndian.Uint32(data[6:10])
+	datagram.Timestamp = time.Duration(binary.BigEndian.Uint64(data[10:18])) * time.Millisecond
+
+	gotMessage := false
+
+	for len(payload) > 0 {
+
+		if len(payload) < 2 || len(payload) < 2+int(payload[1]) {
+			return nil, errors.New("status datagram has a truncated tlv record")
+		}
+
+		tlvType, value := StatusTLVType(payload[0]), payload[2:2+int(payload[1])]
+		payload = payload[2+len(value):]
+
+		switch tlvType {
+		case StatusTLVMessage:
+			if len(value) != len(datagram.Message.Data) {
+				return nil, fmt.Errorf("status datagram has an invalid message length: %v", len(value))
+			}
+			copy(datagram.Message.Data[:], value)
+			gotMessage = true
+		case StatusTLVInterval:
+			if len(value) != 4 {
+				return nil, fmt.Errorf("status datagram has an invalid interval length: %v", len(value))
+			}
+			datagram.Interval = time.Duration(binary.BigEndian.Uint32(value)) * time.Millisecond
+		}
+	}
+
+	if !gotMessage {
+		return nil, errors.New("status datagram has no status message")
+	}
+
+	return datagram, nil
+}
+
+// DecodeStatusAck decodes an acknowledgement and returns the acknowledged sequence
+func DecodeStatusAck(data []byte) (uint32, error) {
+
+	if _, err := decodeStatusHeader(data); err != nil {
+		return 0, err
+	}
+
+	if data[3]&StatusFlagAck == 0 {
+		return 0, errors.New("status datagram is not an acknowledgement")
+	}
+
+	return binary.BigEndian.Uint32(data[6:10]), nil
+}
+
+// decodeStatusHeader validates the header and crc of a v2 datagram and returns the tlv payload
+func decodeStatusHeader(data []byte) ([]byte, error) {
+
+	if len(data) < statusHeaderSize+statusCrcSize {
+		return nil, errStatusTooShort
+	}
+
+	if string(data[0:2]) != statusMagic {
+		return nil, errStatusMagic
+	}
+
+	if data[2] != statusVersion2 {
+		return nil, fmt.Errorf("unsupported status datagram version: %v", data[2])
+	}
+
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+
+	if len(data) != statusHeaderSize+length+statusCrcSize {
+		return nil, fmt.Errorf("status datagram length: %v does not match payload length: %v", len(data), length)
+	}
+
+	crcOffset := len(data) - statusCrcSize
+
+	if crc32.ChecksumIEEE(data[:crcOffset]) != binary.BigEndian.Uint32(data[crcOffset:]) {
+		return nil, errStatusCrc
+	}
+
+	return data[statusHeaderSize:crcOffset], nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+func TestStatusDatagramRoundTrip(t *testing.T) {
+
+	encoder := NewStatusEncoder()
+	msg := NewMessage()
+	msg.ConnectionStatus().SetModemSignal(FairSignal)
+	msg.RimoteStatus().SetRimoteConnected(true)
+
+	for sequence := uint32(0); sequence < 3; sequence++ {
+
+		datagram, err := DecodeStatusDatagram(encoder.Encode(msg, 2*time.Second))
+
+		if err != nil {
+			t.Fatalf("Got unexpected error while decoding: %v", err)
+		}
+
+		if datagram.Version != 2 || datagram.Sequence != sequence {
+			t.Errorf("Expected version: 2 sequence: %v got version: %v sequence: %v", sequence, datagram.Version, datagram.Sequence)
+		}
+
+		if datagram.Message.Data != msg.Data {
+			t.Errorf("Expected message: %x got: %x", msg.Data, datagram.Message.Data)
+		}
+
+		if datagram.Interval != 2*time.Second {
+			t.Errorf("Expected interval: 2s got: %v", datagram.Interval)
+		}
+	}
+}
+
+func TestDecodeLegacyStatusDatagram(t *testing.T) {
+
+	datagram, err := DecodeStatusDatagram([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	if err != nil {
+		t.Fatalf("Got unexpected error while decoding: %v", err)
+	}
+
+	if datagram.Version != 1 || datagram.Message.Data != [8]byte{1, 2, 3, 4, 5, 6, 7, 8} {
+		t.Errorf("Unexpected legacy datagram: %+v", datagram)
+	}
+}
+
+func TestDecodeInvalidStatusDatagram(t *testing.T) {
+
+	valid := NewStatusEncoder().Encode(NewMessage(), time.Second)
+
+	corrupt := func(index int) []byte {
+		data := append([]byte{}, valid...)
+		data[index] ^= 0xFF
+		return data
+	}
+
+	tests := map[string][]byte{
+		"empty":     {},
+		"short":     valid[:10],
+		"magic":     corrupt(0),
+		"version":   corrupt(2),
+		"crc":       corrupt(len(valid) - 1),
+		"payload":   corrupt(statusHeaderSize + 3),
+		"truncated": valid[:len(valid)-1],
+	}
+
+	for name, data := range tests {
+		if _, err := DecodeStatusDatagram(data); err == nil {
+			t.Errorf("Expected an error for: %v", name)
+		}
+	}
+}
+
+func TestDecodeStatusDatagramSkipsUnknownRecords(t *testing.T) {
+
+	msg := NewMessage()
+	msg.GeneralStatus().SetVccStatus(true)
+
+	valid := NewStatusEncoder().Encode(msg, time.Second)
+
+	// Insert a record of an unknown type before the crc
+	data := append([]byte{}, valid[:len(valid)-statusCrcSize]...)
+	data = append(data, 0x7F, 2, 0xAB, 0xCD)
+	binary.BigEndian.PutUint16(data[4:6], binary.BigEndian.Uint16(data[4:6])+4)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-statusCrcSize:], crc32.ChecksumIEEE(data[:len(data)-statusCrcSize]))
+
+	datagram, err := DecodeStatusDatagram(data)
+
+	if err != nil {
+		t.Fatalf("Got unexpected error while decoding: %v", err)
+	}
+
+	if datagram.Message.Data != msg.Data {
+		t.Errorf("Expected message: %x got: %x", msg.Data, datagram.Message.Data)
+	}
+}
+
+func TestStatusAck(t *testing.T) {
+
+	ack := EncodeStatusAck(42, time.Minute)
+
+	if sequence, err := DecodeStatusAck(ack); err != nil || sequence != 42 {
+		t.Errorf("Expected sequence: 42 got: %v error: %v", sequence, err)
+	}
+
+	// An acknowledgement is never mistaken for a status datagram and the other way around
+	if _, err := DecodeStatusDatagram(ack); err == nil {
+		t.Errorf("Expected an error when decoding an acknowledgement as status datagram")
+	}
+
+	if _, err := DecodeStatusAck(NewStatusEncoder().Encode(NewMessage(), time.Second)); err == nil {
+		t.Errorf("Expected an error when decoding a status datagram as acknowledgement")
+	}
+}
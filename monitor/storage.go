@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterMonitor("storage", func(dependencies MonitorDependencies) Monitor {
+		return NewStorageMonitor(dependencies.Logger, dependencies.Store)
+	})
+}
+
+// StorageCheck structure holding the result of a single storage health check
+type StorageCheck struct {
+	Name  string
+	Value float64
+	Limit float64
+	Ok    bool
+	Error string `json:",omitempty"`
+}
+
+// StorageMessage type, Ok is true when every check passed
+type StorageMessage struct {
+	Ok     bool
+	Checks []StorageCheck
+}
+
+// StorageMonitor checks the health of the nand, emmc and filesystems
+type StorageMonitor struct {
+	logger   *Logger
+	store    *ConfigurationStore
+	root     string
+	failed   map[string]bool
+	counters map[string]float64
+	events   chan MonitorEvent
+}
+
+// NewStorageMonitor creates the monitor reading from /sys and /proc
+func NewStorageMonitor(logger *Logger, store *ConfigurationStore) *StorageMonitor {
+	return &StorageMonitor{logger: logger, store: store, root: "/", failed: make(map[string]bool), counters: make(map[string]float64), events: make(chan MonitorEvent)}
+}
+
+// Name of the monitor
+func (monitor *StorageMonitor) Name() string {
+	return "storage"
+}
+
+// Events returns the StorageMessage events
+func (monitor *StorageMonitor) Events() <-chan MonitorEvent {
+	return monitor.events
+}
+
+// Run the monitor until the context is cancelled
+func (monitor *StorageMonitor) Run(ctx context.Context) {
+
+	for {
+
+		changed := monitor.store.Changed()
+		config := monitor.store.Current().Storage
+
+		if !publishEvent(ctx, monitor.events, monitor.Name(), monitor.check(config)) {
+			return
+		}
+
+		// Wait a while, a configuration change will trigger a refresh directly.
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-time.After(config.Interval):
+		}
+	}
+}
+
+// check runs every check which applies to this device and logs the checks which changed state
+func (monitor *StorageMonitor) check(config StorageConfiguration) StorageMessage {
+
+	var checks []StorageCheck
+
+	checks = append(checks, monitor.checkMtd(config)...)
+	checks = append(checks, monitor.checkUbi(config)...)
+	checks = append(checks, monitor.checkEmmc(config)...)
+	checks = append(checks, monitor.checkMounts(config)...)
+
+	// A host without the path, like a development machine, has nothing to check
+	if config.FreeSpacePath != "" && pathExists(filepath.Join(monitor.root, config.FreeSpacePath)) {
+		checks = append(checks, monitor.checkFreeSpace(config))
+	}
+
+	storageMessage := StorageMessage{Ok: true, Checks: checks}
+
+	for _, check := range checks {
+
+		if !check.Ok && !monitor.failed[check.Name] {
+			if check.Error != "" {
+				monitor.logger.Errorf("Storage: %v cannot be checked: %v", check.Name, check.Error)
+			} else {
+				monitor.logger.Warningf("Storage: %v failed: %v limit: %v", check.Name, check.Value, check.Limit)
+			}
+		} else if check.Ok && monitor.failed[check.Name] {
+			monitor.logger.Infof("Storage: %v is ok again: %v", check.Name, check.Value)
+		}
+
+		monitor.failed[check.Name] = !check.Ok
+		storageMessage.Ok = storageMessage.Ok && check.Ok
+	}
+
+	return storageMessage
+}
+
+// checkMtd checks the bad blocks and the new uncorrectable ecc errors of every raw nand partition
+func (monitor *StorageMonitor) checkMtd(config StorageConfiguration) []StorageCheck {
+
+	var checks []StorageCheck
+
+	devices, _ := filepath.Glob(filepath.Join(monitor.root, "sys", "class", "mtd", "mtd*"))
+
+	for _, device := range devices {
+
+		name := filepath.Base(device)
+
+		// mtdXro is the read-only view of the same partition
+		if strings.HasSuffix(name, "ro") {
+			continue
+		}
+
+		checks = append(checks,
+			maximumCheck(name+".bad_blocks", filepath.Join(device, "bad_blocks"), config.MaxBadBlocks),
+			monitor.increaseCheck(name+".ecc_failures", filepath.Join(device, "ecc_failures"), config.MaxECCFailures))
+	}
+
+	return checks
+}
+
+// checkUbi checks every ubi device still has blocks reserved to replace bad blocks
+func (monitor *StorageMonitor) checkUbi(config StorageConfiguration) []StorageCheck {
+
+	var checks []StorageCheck
+
+	devices, _ := filepath.Glob(filepath.Join(monitor.root, "sys", "class", "ubi", "ubi*"))
+
+	for _, device := range devices {
+
+		name := filepath.Base(device)
+
+		// Skip the volumes (ubiX_Y) and the control device
+		if strings.Contains(name, "_") {
+			continue
+		}
+
+		check := StorageCheck{Name: name + ".reserved_for_bad", Limit: float64(config.MinReservedPEBs)}
+		value, err := readStorageValue(filepath.Join(device, "reserved_for_bad"))
+
+		if err != nil {
+			check.Error = err.Error()
+		} else {
+			check.Value = value
+			check.Ok = value >= check.Limit
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+// checkEmmc checks the wear estimate and the reserved block usage of every emmc
+func (monitor *StorageMonitor) checkEmmc(config StorageConfiguration) []StorageCheck {
+
+	var checks []StorageCheck
+
+	cards, _ := filepath.Glob(filepath.Join(monitor.root, "sys", "class", "mmc_host", "*", "*", "life_time"))
+
+	for _, lifeTime := range cards {
+
+		card := filepath.Dir(lifeTime)
+		name := filepath.Base(card)
+
+		checks = append(checks,
+			maximumCheck(name+".life_time", lifeTime, config.MaxLifeTime),
+			maximumCheck(name+".pre_eol_info", filepath.Join(card, "pre_eol_info"), config.MaxPreEOL))
+	}
+
+	return checks
+}
+
+// checkMounts checks the mounts which must be writable were not remounted read-only after an error
+func (monitor *StorageMonitor) checkMounts(config StorageConfiguration) []StorageCheck {
+
+	if len(config.WritableMounts) == 0 {
+		return nil
+	}
+
+	readOnly, err := readOnlyMounts(filepath.Join(monitor.root, "proc", "mounts"))
+
+	var checks []StorageCheck
+
+	for _, mount := range config.WritableMounts {
+
+		// A mount point which doesn't exist doesn't apply to this device
+		if !pathExists(filepath.Join(monitor.root, mount)) {
+			continue
+		}
+
+		check := StorageCheck{Name: "mount:" + mount + ".read_only"}
+
+		if err != nil {
+			check.Error = err.Error()
+		} else if ro, mounted := readOnly[mount]; mounted {
+			check.Ok = !ro
+			if ro {
+				check.Value = 1
+			}
+		} else {
+			check.Error = "not mounted"
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+// checkFreeSpace checks the free space percentage of the filesystem of the path
+func (monitor *StorageMonitor) checkFreeSpace(config StorageConfiguration) StorageCheck {
+
+	check := StorageCheck{Name: "free:" + config.FreeSpacePath, Limit: config.MinFreePercent}
+	free, total, err := diskSpace(filepath.Join(monitor.root, config.FreeSpacePath))
+
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	if total > 0 {
+		check.Value = float64(free) * 100 / float64(total)
+	}
+
+	check.Ok = check.Value >= check.Limit
+
+	return check
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// maximumCheck passes when the counter in the file does not exceed the maximum
+func maximumCheck(name string, path string, maximum int) StorageCheck {
+
+	check := StorageCheck{Name: name, Limit: float64(maximum)}
+	value, err := readStorageValue(path)
+
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	check.Value = value
+	check.Ok = value <= check.Limit
+
+	return check
+}
+
+// increaseCheck passes when the counter in the file did not grow more than the maximum since the previous check, a
+// counter which only resets on boot would otherwise fail the check for good after a single error
+func (monitor *StorageMonitor) increaseCheck(name string, path string, maximum int) StorageCheck {
+
+	check := StorageCheck{Name: name, Limit: float64(maximum)}
+	value, err := readStorageValue(path)
+
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	previous := monitor.counters[name]
+
+	// The counter starts over when the driver is reloaded
+	if value < previous {
+		previous = 0
+	}
+
+	monitor.counters[name] = value
+
+	check.Value = value - previous
+	check.Ok = check.Value <= check.Limit
+
+	return check
+}
+
+// readStorageValue reads a decimal or hex counter, when the file holds several values the highest is returned
+func readStorageValue(path string) (float64, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("%v is empty", path)
+	}
+
+	highest := int64(0)
+
+	// The emmc life_time holds the estimates of type A and type B memory: "0x01 0x02"
+	for _, field := range fields {
+
+		value, err := strconv.ParseInt(field, 0, 64)
+
+		if err != nil {
+			return 0, fmt.Errorf("%v: %v", path, err)
+		}
+
+		if value > highest {
+			highest = value
+		}
+	}
+
+	return float64(highest), nil
+}
+
+// readOnlyMounts returns for every mount point whether it's mounted read-only, the last mount of a point wins
+func readOnlyMounts(path string) (map[string]bool, error) {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	mounts := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 4 {
+			continue
+		}
+
+		readOnly := false
+
+		for _, option := range strings.Split(fields[3], ",") {
+			if option == "ro" {
+				readOnly = true
+			}
+		}
+
+		mounts[fields[1]] = readOnly
+	}
+
+	return mounts, scanner.Err()
+}
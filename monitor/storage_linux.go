@@ -0,0 +1,17 @@
+// +build linux
+
+package main
+
+import "syscall"
+
+// diskSpace returns the bytes available to unprivileged users and the size of the filesystem of the path
+func diskSpace(path string) (uint64, uint64, error) {
+
+	stat := syscall.Statfs_t{}
+
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStorageMonitorCheck(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "rm-monitor")
+
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(root)
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	writeSysfsFiles(t, root, map[string]string{
+		"sys/class/mtd/mtd0/bad_blocks":                  "3\n",
+		"sys/class/mtd/mtd0/ecc_failures":                "0\n",
+		"sys/class/mtd/mtd0ro/bad_blocks":                "3\n",
+		"sys/class/ubi/ubi0/reserved_for_bad":            "20\n",
+		"sys/class/ubi/ubi0_0/name":                      "rootfs\n",
+		"sys/class/mmc_host/mmc0/mmc0:0001/life_time":    "0x02 0x03\n",
+		"sys/class/mmc_host/mmc0/mmc0:0001/pre_eol_info": "0x01\n",
+		// An sd card has no wear information
+		"sys/class/mmc_host/mmc1/mmc1:aaaa/name": "SD16G\n",
+		"proc/mounts": "/dev/root / ext4 ro,relatime 0 0\n" +
+			"/dev/mmcblk0p4 /data ext4 rw,relatime 0 0\n",
+		"data/lost+found/.keep": "",
+	})
+
+	config := DefaultConfiguration().Storage
+	config.FreeSpacePath = "/data"
+	config.MinFreePercent = 0
+
+	monitor := NewStorageMonitor(logger, NewConfigurationStore("", DefaultConfiguration()))
+	monitor.root = root
+
+	storageMessage := monitor.check(config)
+
+	// A negative value is not compared, the free space depends on the machine running the test
+	expected := map[string]float64{
+		"mtd0.bad_blocks":              3,
+		"mtd0.ecc_failures":            0,
+		"ubi0.reserved_for_bad":        20,
+		"mmc0:0001.life_time":          3,
+		"mmc0:0001.pre_eol_info":       1,
+		"mount:/data.read_only":        0,
+		"free:" + config.FreeSpacePath: -1,
+	}
+
+	if !storageMessage.Ok || len(storageMessage.Checks) != len(expected) {
+		t.Fatalf("Expected %v passing checks got: %+v", len(expected), storageMessage)
+	}
+
+	for _, check := range storageMessage.Checks {
+
+		value, ok := expected[check.Name]
+
+		if !ok {
+			t.Errorf("Unexpected check: %+v", check)
+		} else if value >= 0 && check.Value != value {
+			t.Errorf("Expected: %v for: %v got: %v", value, check.Name, check.Value)
+		}
+	}
+
+	// Every threshold fails the nand status on its own
+	failures := []struct {
+		name  string
+		files map[string]string
+		apply func(config *StorageConfiguration)
+	}{
+		{name: "bad blocks", files: map[string]string{"sys/class/mtd/mtd0/bad_blocks": "41"}},
+		{name: "ecc failures", files: map[string]string{"sys/class/mtd/mtd0/ecc_failures": "1"}},
+		{name: "reserved pebs", files: map[string]string{"sys/class/ubi/ubi0/reserved_for_bad": "0"}},
+		{name: "life time", files: map[string]string{"sys/class/mmc_host/mmc0/mmc0:0001/life_time": "0x02 0x0A"}},
+		{name: "pre eol", files: map[string]string{"sys/class/mmc_host/mmc0/mmc0:0001/pre_eol_info": "0x03"}},
+		{name: "read only", files: map[string]string{"proc/mounts": "/dev/mmcblk0p4 /data ext4 rw 0 0\n/dev/mmcblk0p4 /data ext4 ro 0 0\n"}},
+		{name: "not mounted", files: map[string]string{"proc/mounts": "/dev/root / ext4 ro 0 0\n"}},
+		{name: "free space", apply: func(config *StorageConfiguration) { config.MinFreePercent = 101 }},
+	}
+
+	for _, failure := range failures {
+
+		failing := config
+
+		if failure.apply != nil {
+			failure.apply(&failing)
+		}
+
+		writeSysfsFiles(t, root, failure.files)
+
+		if storageMessage := monitor.check(failing); storageMessage.Ok {
+			t.Errorf("Expected the %v check to fail got: %+v", failure.name, storageMessage)
+		}
+
+		// Restore the healthy state
+		writeSysfsFiles(t, root, map[string]string{
+			"sys/class/mtd/mtd0/bad_blocks":                  "3",
+			"sys/class/mtd/mtd0/ecc_failures":                "0",
+			"sys/class/ubi/ubi0/reserved_for_bad":            "20",
+			"sys/class/mmc_host/mmc0/mmc0:0001/life_time":    "0x02 0x03",
+			"sys/class/mmc_host/mmc0/mmc0:0001/pre_eol_info": "0x01",
+			"proc/mounts": "/dev/mmcblk0p4 /data ext4 rw 0 0\n",
+		})
+	}
+
+	if storageMessage := monitor.check(config); !storageMessage.Ok {
+		t.Errorf("Expected the storage to be healthy again got: %+v", storageMessage)
+	}
+
+	// Only new ecc failures fail the check, the counter is not reset until the next boot
+	writeSysfsFiles(t, root, map[string]string{"sys/class/mtd/mtd0/ecc_failures": "2"})
+
+	if storageMessage := monitor.check(config); storageMessage.Ok {
+		t.Errorf("Expected the new ecc failures to fail got: %+v", storageMessage)
+	}
+
+	if storageMessage := monitor.check(config); !storageMessage.Ok {
+		t.Errorf("Expected the unchanged ecc failures to pass got: %+v", storageMessage)
+	}
+
+	// A host without the paths, like a development machine, skips their checks
+	missing := config
+	missing.WritableMounts = []string{"/missing"}
+	missing.FreeSpacePath = "/missing"
+
+	if storageMessage := monitor.check(missing); !storageMessage.Ok || len(storageMessage.Checks) != len(expected)-2 {
+		t.Errorf("Expected the checks of the missing paths to be skipped got: %+v", storageMessage)
+	}
+}
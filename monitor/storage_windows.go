@@ -0,0 +1,30 @@
+// +build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskSpace returns the bytes available to the user and the size of the volume of the path
+func diskSpace(path string) (uint64, uint64, error) {
+
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var available, total, free uint64
+
+	r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&available)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&free)))
+
+	if r == 0 {
+		return 0, 0, err
+	}
+
+	return available, total, nil
+}
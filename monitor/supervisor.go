@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MonitorStalled is published by the supervisor when a monitor made no progress within the stale timeout
+type MonitorStalled struct {
+	LastBeat time.Time
+}
+
+// MonitorCrashed is published by the supervisor when a monitor panicked
+type MonitorCrashed struct {
+	Reason string
+}
+
+// Supervisor starts the monitors, merges their events and restarts monitors which stopped making progress
+type Supervisor struct {
+	registry     *MonitorRegistry
+	dependencies MonitorDependencies
+	heartbeats   *Heartbeats
+	events       chan MonitorEvent
+
+	mutex     sync.Mutex
+	monitors  []*supervisedMonitor
+	observers []EventObserver
+	running   sync.WaitGroup
+}
+
+type supervisedMonitor struct {
+	monitor Monitor
+	cancel  context.CancelFunc
+	// done is closed when the Run of the monitor returned
+	done       chan struct{}
+	backingOff bool
+	// stopping is set while a stalled monitor is waited for before it's replaced
+	stopping bool
+}
+
+// NewSupervisor creates the enabled monitors of the registry
+func NewSupervisor(registry *MonitorRegistry, dependencies MonitorDependencies, enabled func(name string) bool, heartbeats *Heartbeats) *Supervisor {
+
+	supervisor := &Supervisor{
+		registry:     registry,
+		dependencies: dependencies,
+		heartbeats:   heartbeats,
+		events:       make(chan MonitorEvent),
+	}
+
+	for _, monitor := range registry.Create(dependencies, enabled) {
+
+		// Observers are called from the message loop so they must not be restarted
+		if observer, ok := monitor.(EventObserver); ok {
+			supervisor.observers = append(supervisor.observers, observer)
+		}
+
+		// Only monitors with events can report progress
+		if monitor.Events() != nil {
+			heartbeats.Beat(monitor.Name())
+		}
+
+		supervisor.monitors = append(supervisor.monitors, &supervisedMonitor{monitor: monitor})
+	}
+
+	return supervisor
+}
+
+// Events returns the merged events of all monitors
+func (supervisor *Supervisor) Events() <-chan MonitorEvent {
+	return supervisor.events
+}
+
+// Observe passes the event to every monitor observing events
+func (supervisor *Supervisor) Observe(event MonitorEvent) {
+	for _, observer := range supervisor.observers {
+		observer.Observe(event)
+	}
+}
+
+// Start starts all monitors and the supervision until the context is cancelled
+func (supervisor *Supervisor) Start(ctx context.Context) {
+
+	supervisor.mutex.Lock()
+
+	for _, supervised := range supervisor.monitors {
+		supervisor.start(ctx, supervised)
+	}
+
+	supervisor.mutex.Unlock()
+
+	supervisor.running.Add(1)
+
+	go func() {
+		defer supervisor.running.Done()
+		supervisor.run(ctx)
+	}()
+}
+
+// Wait waits until every monitor stopped, it returns false when they did not stop within the timeout
+func (supervisor *Supervisor) Wait(timeout time.Duration) bool {
+
+	stopped := make(chan struct{})
+
+	go func() {
+		supervisor.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (supervisor *Supervisor) start(ctx context.Context, supervised *supervisedMonitor) {
+
+	monitorCtx, cancel := context.WithCancel(ctx)
+	supervised.cancel = cancel
+
+	monitor := supervised.monitor
+	events := monitor.Events()
+	done := make(chan struct{})
+	supervised.done = done
+
+	supervisor.running.Add(1)
+
+	go func() {
+		defer supervisor.running.Done()
+		defer close(done)
+		supervisor.runMonitor(monitorCtx, supervised, monitor)
+	}()
+
+	if events == nil {
+		return
+	}
+
+	supervisor.running.Add(1)
+
+	go func() {
+		defer supervisor.running.Done()
+		for {
+			select {
+			case <-monitorCtx.Done():
+				return
+			case event := <-events:
+				if !forwardEvent(monitorCtx, supervisor.events, event) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// runMonitor runs the monitor until the context is cancelled, a panic restarts the monitor after a backoff
+func (supervisor *Supervisor) runMonitor(ctx context.Context, supervised *supervisedMonitor, monitor Monitor) {
+
+	name := monitor.Name()
+	crashes := 0
+
+	for {
+		started := time.Now()
+		reason, crashed := supervisor.runRecovered(ctx, monitor)
+
+		if !crashed || ctx.Err() != nil {
+			return
+		}
+
+		config := supervisor.dependencies.Store.Current().Supervisor
+
+		// A monitor which ran stable for a while starts over with the shortest backoff
+		if time.Since(started) > config.MaxRestartBackoff {
+			crashes = 0
+		}
+
+		crashes++
+		backoff := RestartBackoff(config, crashes)
+
+		metrics.MonitorCrashes.Inc(name)
+		supervisor.dependencies.Recorder.RecordMonitorCrash(name, reason)
+		supervisor.dependencies.Logger.Errorf("Monitor: %v crashed %v times in a row restarting in %v", name, crashes, backoff)
+
+		// Let the message loop clear the status of the monitor
+		if !publishEvent(ctx, supervisor.events, name, MonitorCrashed{Reason: reason}) {
+			return
+		}
+
+		supervisor.setBackingOff(supervised, monitor, true)
+		restart := sleepWithContext(ctx, backoff)
+		supervisor.setBackingOff(supervised, monitor, false)
+
+		if !restart {
+			return
+		}
+
+		// Give the monitor a full period to report
+		supervisor.heartbeats.Beat(name)
+	}
+}
+
+// runRecovered runs the monitor, a panic is logged and returned as reason
+func (supervisor *Supervisor) runRecovered(ctx context.Context, monitor Monitor) (reason string, crashed bool) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			reason = fmt.Sprint(r)
+			crashed = true
+			supervisor.dependencies.Logger.StackAsError(fmt.Sprintf("Monitor: %v panicked: %v", monitor.Name(), r))
+		}
+	}()
+
+	monitor.Run(ctx)
+
+	return "", false
+}
+
+// setBackingOff marks the monitor as waiting for a restart so the stale check leaves it alone
+func (supervisor *Supervisor) setBackingOff(supervised *supervisedMonitor, monitor Monitor, backingOff bool) {
+
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	// The monitor is already replaced by the stale check
+	if supervised.monitor == monitor {
+		supervised.backingOff = backingOff
+	}
+}
+
+// RestartBackoff returns the backoff before restarting a monitor which crashed the given times in a row
+func RestartBackoff(config SupervisorConfiguration, crashes int) time.Duration {
+
+	backoff := config.RestartBackoff
+
+	for i := 1; i < crashes && backoff < config.MaxRestartBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > config.MaxRestartBackoff {
+		return config.MaxRestartBackoff
+	}
+
+	return backoff
+}
+
+func (supervisor *Supervisor) run(ctx context.Context) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(supervisor.dependencies.Store.Current().Supervisor.CheckInterval):
+			supervisor.check(ctx)
+		}
+	}
+}
+
+// check restarts the monitors without a heartbeat within the stale timeout
+func (supervisor *Supervisor) check(ctx context.Context) {
+
+	staleTimeout := supervisor.dependencies.Store.Current().Supervisor.StaleTimeout
+
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	for _, supervised := range supervisor.monitors {
+
+		// Monitors without events cannot report progress so we cannot supervise them
+		if supervised.monitor.Events() == nil || supervised.backingOff || supervised.stopping {
+			continue
+		}
+
+		name := supervised.monitor.Name()
+		lastBeat, _ := supervisor.heartbeats.LastBeat(name)
+
+		if time.Since(lastBeat) <= staleTimeout {
+			continue
+		}
+
+		supervisor.dependencies.Logger.Errorf("Monitor: %v made no progress since: %v restarting", name, lastBeat.Format(time.RFC3339))
+
+		// Let the message loop clear the status of the monitor
+		if !publishEvent(ctx, supervisor.events, name, MonitorStalled{LastBeat: lastBeat}) {
+			return
+		}
+
+		supervised.cancel()
+		supervised.stopping = true
+		supervisor.running.Add(1)
+
+		go func(supervised *supervisedMonitor, done chan struct{}) {
+			defer supervisor.running.Done()
+			supervisor.replace(ctx, supervised, done)
+		}(supervised, supervised.done)
+	}
+}
+
+// replace starts a new instance of the stalled monitor once its Run returned, a monitor stuck in a read keeps
+// running until the read returns and the new instance would compete for its resources, like the modem port
+func (supervisor *Supervisor) replace(ctx context.Context, supervised *supervisedMonitor, done chan struct{}) {
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-done:
+	}
+
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	name := supervised.monitor.Name()
+
+	supervised.stopping = false
+	supervised.monitor = supervisor.registry.factories[name](supervisor.dependencies)
+	metrics.MonitorRestarts.Inc(name)
+	supervisor.dependencies.Recorder.RecordMonitorRestart(name)
+
+	// Give the new monitor a full period to report
+	supervisor.heartbeats.Beat(name)
+	supervisor.start(ctx, supervised)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type fakeMonitor struct {
+	name   string
+	events chan MonitorEvent
+}
+
+func (monitor *fakeMonitor) Name() string {
+	return monitor.name
+}
+
+func (monitor *fakeMonitor) Events() <-chan MonitorEvent {
+	if monitor.events == nil {
+		return nil
+	}
+
+	return monitor.events
+}
+
+func (monitor *fakeMonitor) Run(ctx context.Context) {
+
+	if monitor.events != nil {
+		publishEvent(ctx, monitor.events, monitor.name, monitor.name+"-payload")
+	}
+
+	<-ctx.Done()
+}
+
+func newFakeMonitorRegistry(names ...string) *MonitorRegistry {
+
+	registry := NewMonitorRegistry()
+
+	for _, name := range names {
+		monitorName := name
+		registry.Register(monitorName, func(dependencies MonitorDependencies) Monitor {
+			return &fakeMonitor{name: monitorName, events: make(chan MonitorEvent)}
+		})
+	}
+
+	return registry
+}
+
+func TestSupervisorCreatesEnabledMonitors(t *testing.T) {
+
+	registry := newFakeMonitorRegistry("rimote", "ethernet", "modem")
+
+	config := DefaultConfiguration()
+	config.Monitors = map[string]bool{"modem": false, "rimote": true}
+
+	supervisor := newTestSupervisor(t, registry, config)
+
+	names := []string{}
+	for _, supervised := range supervisor.monitors {
+		names = append(names, supervised.monitor.Name())
+	}
+
+	if expected := []string{"ethernet", "rimote"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected monitors: %v got: %v", expected, names)
+	}
+}
+
+func newTestSupervisor(t *testing.T, registry *MonitorRegistry, config *Configuration) *Supervisor {
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	dependencies := MonitorDependencies{Logger: logger, Store: NewConfigurationStore("", config), Recorder: NewStatusRecorder()}
+
+	return NewSupervisor(registry, dependencies, config.MonitorEnabled, NewHeartbeats())
+}
+
+func TestSupervisorMergesEvents(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	registry := newFakeMonitorRegistry("ethernet", "rimote")
+	registry.Register("hostinfo", func(dependencies MonitorDependencies) Monitor {
+		return &fakeMonitor{name: "hostinfo"}
+	})
+
+	supervisor := newTestSupervisor(t, registry, DefaultConfiguration())
+	supervisor.Start(ctx)
+
+	sources := []string{}
+
+	for len(sources) < 2 {
+		select {
+		case event := <-supervisor.Events():
+			if event.Payload != event.Source+"-payload" {
+				t.Errorf("Expected payload of %v got: %v", event.Source, event.Payload)
+			}
+			sources = append(sources, event.Source)
+		case <-ctx.Done():
+			t.Fatalf("Timeout while waiting for events, got: %v", sources)
+		}
+	}
+
+	sort.Strings(sources)
+
+	if expected := []string{"ethernet", "rimote"}; !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Expected events from: %v got: %v", expected, sources)
+	}
+
+	cancel()
+
+	if !supervisor.Wait(time.Second) {
+		t.Errorf("Expected every monitor to stop after cancelling the context")
+	}
+}
+
+func TestSupervisorRestartsStalledMonitor(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config := DefaultConfiguration()
+	config.Supervisor.StaleTimeout = time.Millisecond
+
+	supervisor := newTestSupervisor(t, newFakeMonitorRegistry("modem"), config)
+	supervisor.Start(ctx)
+
+	// Swallow the first event so the monitor makes no progress
+	<-supervisor.Events()
+	time.Sleep(5 * time.Millisecond)
+
+	restarts := metrics.MonitorRestarts.Value("modem")
+	go supervisor.check(ctx)
+
+	expected := []interface{}{MonitorStalled{}, "modem-payload"}
+
+	for _, payload := range expected {
+		select {
+		case event := <-supervisor.Events():
+			if stalled, ok := event.Payload.(MonitorStalled); ok {
+				stalled.LastBeat = time.Time{}
+				event.Payload = stalled
+			}
+
+			if event.Payload != payload {
+				t.Errorf("Expected payload: %v got: %v", payload, event.Payload)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timeout while waiting for: %v", payload)
+		}
+	}
+
+	if value := metrics.MonitorRestarts.Value("modem"); value != restarts+1 {
+		t.Errorf("Expected restarts: %v got: %v", restarts+1, value)
+	}
+}
+
+// stuckMonitor ignores the cancellation until it's released, like a monitor stuck in a read
+type stuckMonitor struct {
+	events  chan MonitorEvent
+	release chan struct{}
+}
+
+func (monitor *stuckMonitor) Name() string {
+	return "modem"
+}
+
+func (monitor *stuckMonitor) Events() <-chan MonitorEvent {
+	return monitor.events
+}
+
+func (monitor *stuckMonitor) Run(ctx context.Context) {
+	publishEvent(ctx, monitor.events, monitor.Name(), "started")
+	<-monitor.release
+}
+
+func TestSupervisorWaitsForStalledMonitor(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	release := make(chan struct{})
+	created := make(chan struct{}, 2)
+
+	registry := NewMonitorRegistry()
+	registry.Register("modem", func(dependencies MonitorDependencies) Monitor {
+		created <- struct{}{}
+		return &stuckMonitor{events: make(chan MonitorEvent), release: release}
+	})
+
+	config := DefaultConfiguration()
+	config.Supervisor.StaleTimeout = time.Millisecond
+
+	supervisor := newTestSupervisor(t, registry, config)
+	<-created
+	supervisor.Start(ctx)
+
+	<-supervisor.Events()
+	time.Sleep(5 * time.Millisecond)
+
+	go supervisor.check(ctx)
+
+	event := <-supervisor.Events()
+
+	if _, ok := event.Payload.(MonitorStalled); !ok {
+		t.Fatalf("Expected the monitor to be stalled got: %v", event.Payload)
+	}
+
+	// The stalled monitor is not checked again and not replaced while it's running
+	supervisor.check(ctx)
+
+	select {
+	case <-created:
+		t.Fatalf("Expected no new monitor while the stalled one is running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-created:
+	case <-ctx.Done():
+		t.Fatalf("Expected a new monitor after the stalled one returned")
+	}
+
+	if event := <-supervisor.Events(); event.Payload != "started" {
+		t.Errorf("Expected the new monitor to start got: %v", event.Payload)
+	}
+}
+
+type panickingMonitor struct {
+	runs   int
+	events chan MonitorEvent
+}
+
+func (monitor *panickingMonitor) Name() string {
+	return "modem"
+}
+
+func (monitor *panickingMonitor) Events() <-chan MonitorEvent {
+	return monitor.events
+}
+
+func (monitor *panickingMonitor) Run(ctx context.Context) {
+
+	monitor.runs++
+
+	if monitor.runs == 1 {
+		var items []string
+		_ = items[1]
+	}
+
+	publishEvent(ctx, monitor.events, monitor.Name(), "recovered")
+	<-ctx.Done()
+}
+
+func TestSupervisorRecoversPanics(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	registry := NewMonitorRegistry()
+	registry.Register("modem", func(dependencies MonitorDependencies) Monitor {
+		return &panickingMonitor{events: make(chan MonitorEvent)}
+	})
+
+	config := DefaultConfiguration()
+	config.Supervisor.RestartBackoff = time.Millisecond
+
+	supervisor := newTestSupervisor(t, registry, config)
+	crashes := metrics.MonitorCrashes.Value("modem")
+	supervisor.Start(ctx)
+
+	for _, expected := range []string{"crashed", "recovered"} {
+		select {
+		case event := <-supervisor.Events():
+			payload := event.Payload
+			if _, ok := payload.(MonitorCrashed); ok {
+				payload = "crashed"
+			}
+
+			if payload != expected {
+				t.Errorf("Expected payload: %v got: %v", expected, event.Payload)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timeout while waiting for: %v", expected)
+		}
+	}
+
+	if value := metrics.MonitorCrashes.Value("modem"); value != crashes+1 {
+		t.Errorf("Expected crashes: %v got: %v", crashes+1, value)
+	}
+
+	if status := supervisor.dependencies.Recorder.Snapshot().Monitors["modem"]; status.Crashes != 1 || status.Reason == "" {
+		t.Errorf("Expected one recorded crash with a reason got: %+v", status)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+
+	config := SupervisorConfiguration{RestartBackoff: time.Second, MaxRestartBackoff: 10 * time.Second}
+
+	tests := []struct {
+		crashes  int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		if backoff := RestartBackoff(config, test.crashes); backoff != test.expected {
+			t.Errorf("Crashes: %v expected backoff: %v got: %v", test.crashes, test.expected, backoff)
+		}
+	}
+}
+
+func TestValidateUnknownMonitor(t *testing.T) {
+
+	config := DefaultConfiguration()
+	config.Monitors = map[string]bool{"bluetooth": true}
+
+	if err := config.Validate(); err == nil {
+		t.Errorf("Expected an error for an unknown monitor")
+	}
+}
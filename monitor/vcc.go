@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterMonitor("vcc", func(dependencies MonitorDependencies) Monitor {
+		return NewVccMonitor(dependencies.Logger, dependencies.Store)
+	})
+}
+
+// VccState type
+type VccState string
+
+const (
+	// VccOk the voltage is within range
+	VccOk VccState = "ok"
+	// VccUnder the voltage is below the minimum
+	VccUnder VccState = "under"
+	// VccOver the voltage is above the maximum
+	VccOver VccState = "over"
+	// VccError the voltage cannot be read
+	VccError VccState = "error"
+)
+
+// VccChannelStatus structure holding the last measurement of a supply channel
+type VccChannelStatus struct {
+	Name    string
+	Voltage float64
+	State   VccState
+	Error   string `json:",omitempty"`
+}
+
+// VccMessage type, Ok is true when every channel is within range
+type VccMessage struct {
+	Ok       bool
+	Channels []VccChannelStatus
+}
+
+// VccMonitor measures the supply voltages of the channels in the configuration
+type VccMonitor struct {
+	logger *Logger
+	store  *ConfigurationStore
+	root   string
+	states map[string]VccState
+	events chan MonitorEvent
+}
+
+// NewVccMonitor creates the monitor reading from /sys
+func NewVccMonitor(logger *Logger, store *ConfigurationStore) *VccMonitor {
+	return &VccMonitor{logger: logger, store: store, root: "/sys", states: make(map[string]VccState), events: make(chan MonitorEvent)}
+}
+
+// Name of the monitor
+func (monitor *VccMonitor) Name() string {
+	return "vcc"
+}
+
+// Events returns the VccMessage events
+func (monitor *VccMonitor) Events() <-chan MonitorEvent {
+	return monitor.events
+}
+
+// Run the monitor until the context is cancelled
+func (monitor *VccMonitor) Run(ctx context.Context) {
+
+	for {
+
+		changed := monitor.store.Changed()
+		config := monitor.store.Current().Vcc
+
+		if !publishEvent(ctx, monitor.events, monitor.Name(), monitor.measure(config.Channels)) {
+			return
+		}
+
+		// Wait a while, a configuration change will trigger a refresh directly.
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-time.After(config.Interval):
+		}
+	}
+}
+
+// measure reads every channel and logs the channels which changed state
+func (monitor *VccMonitor) measure(channels []VccChannel) VccMessage {
+
+	vccMessage := VccMessage{Ok: true}
+
+	for _, channel := range channels {
+
+		status := VccChannelStatus{Name: channel.Name}
+		previous := monitor.states[channel.Name]
+
+		voltage, err := readVccChannel(monitor.root, channel)
+
+		if err != nil {
+			status.State = VccError
+			status.Error = err.Error()
+		} else {
+			status.Voltage = voltage
+			status.State = channel.Evaluate(previous, voltage)
+		}
+
+		if status.State != previous {
+			monitor.logTransition(channel, previous, status)
+		}
+
+		monitor.states[channel.Name] = status.State
+		vccMessage.Ok = vccMessage.Ok && status.State == VccOk
+		vccMessage.Channels = append(vccMessage.Channels, status)
+	}
+
+	return vccMessage
+}
+
+func (monitor *VccMonitor) logTransition(channel VccChannel, previous VccState, status VccChannelStatus) {
+
+	switch status.State {
+	case VccOk:
+		// The first measurement within range is not worth logging
+		if previous != "" {
+			monitor.logger.Infof("Supply: %v back in range: %.3fV", channel.Name, status.Voltage)
+		}
+	case VccUnder:
+		monitor.logger.Warningf("Supply: %v under voltage: %.3fV minimum: %.3fV", channel.Name, status.Voltage, channel.Min)
+	case VccOver:
+		monitor.logger.Warningf("Supply: %v over voltage: %.3fV maximum: %.3fV", channel.Name, status.Voltage, channel.Max)
+	case VccError:
+		monitor.logger.Errorf("Supply: %v cannot be read: %v", channel.Name, status.Error)
+	}
+}
+
+// Evaluate returns the state of the voltage, a channel out of range must be back within the hysteresis to be ok again
+func (channel VccChannel) Evaluate(previous VccState, voltage float64) VccState {
+
+	min, max := channel.Min, channel.Max
+
+	switch previous {
+	case VccUnder:
+		min += channel.Hysteresis
+	case VccOver:
+		max -= channel.Hysteresis
+	}
+
+	if voltage < min {
+		return VccUnder
+	}
+
+	// Without a maximum there's no upper limit
+	if channel.Max > 0 && voltage > max {
+		return VccOver
+	}
+
+	return VccOk
+}
+
+// readVccChannel reads the voltage of a hwmon or iio input in volts
+func readVccChannel(root string, channel VccChannel) (float64, error) {
+
+	var millivolts float64
+	var err error
+
+	if channel.Hwmon != "" {
+		millivolts, err = readHwmonInput(root, channel.Hwmon, channel.Input)
+	} else {
+		millivolts, err = readIIOInput(root, channel.IIO, channel.Input)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	// The scale corrects for a voltage divider in front of the adc
+	scale := channel.Scale
+
+	if scale == 0 {
+		scale = 1
+	}
+
+	return millivolts / 1000 * scale, nil
+}
+
+// readHwmonInput reads the <input>_input file in millivolts of the hwmon device with the name
+func readHwmonInput(root string, name string, input string) (float64, error) {
+
+	device, err := findSysfsDevice(filepath.Join(root, "class", "hwmon", "hwmon*"), name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return readSysfsFloat(filepath.Join(device, input+"_input"))
+}
+
+// readIIOInput reads the <input>_raw file of the iio device with the name and applies the offset and scale
+func readIIOInput(root string, name string, input string) (float64, error) {
+
+	device, err := findSysfsDevice(filepath.Join(root, "bus", "iio", "devices", "iio:device*"), name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	raw, err := readSysfsFloat(filepath.Join(device, input+"_raw"))
+
+	if err != nil {
+		return 0, err
+	}
+
+	// The scale and offset are either per channel or shared by every channel of the type
+	shared := strings.TrimRight(input, "0123456789")
+
+	offset, err := readSysfsFloat(filepath.Join(device, input+"_offset"))
+
+	if err != nil {
+		offset, _ = readSysfsFloat(filepath.Join(device, shared+"_offset"))
+	}
+
+	scale, err := readSysfsFloat(filepath.Join(device, input+"_scale"))
+
+	if err != nil {
+		scale, err = readSysfsFloat(filepath.Join(device, shared+"_scale"))
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("iio device: %v has no scale for: %v", name, input)
+	}
+
+	return (raw + offset) * scale, nil
+}
+
+// findSysfsDevice returns the device directory matching the pattern with the name or directory name
+func findSysfsDevice(pattern string, name string) (string, error) {
+
+	devices, err := filepath.Glob(pattern)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, device := range devices {
+
+		if filepath.Base(device) == name {
+			return device, nil
+		}
+
+		if data, err := ioutil.ReadFile(filepath.Join(device, "name")); err == nil && strings.TrimSpace(string(data)) == name {
+			return device, nil
+		}
+	}
+
+	return "", fmt.Errorf("no device named: %v in: %v", name, filepath.Dir(pattern))
+}
+
+func readSysfsFloat(path string) (float64, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+}
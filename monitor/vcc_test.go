@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSysfsFiles(t *testing.T, root string, files map[string]string) {
+
+	for name, content := range files {
+
+		path := filepath.Join(root, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Cannot create dir: %v", err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Cannot write file: %v", err)
+		}
+	}
+}
+
+func TestVccChannelEvaluate(t *testing.T) {
+
+	channel := VccChannel{Min: 4.75, Max: 5.25, Hysteresis: 0.1}
+
+	tests := []struct {
+		previous VccState
+		voltage  float64
+		expected VccState
+	}{
+		{previous: "", voltage: 5.0, expected: VccOk},
+		{previous: VccOk, voltage: 4.74, expected: VccUnder},
+		{previous: VccOk, voltage: 5.26, expected: VccOver},
+		{previous: VccOk, voltage: 4.8, expected: VccOk},
+		{previous: VccUnder, voltage: 4.8, expected: VccUnder},
+		{previous: VccUnder, voltage: 4.86, expected: VccOk},
+		{previous: VccUnder, voltage: 5.2, expected: VccOk},
+		{previous: VccOver, voltage: 5.2, expected: VccOver},
+		{previous: VccOver, voltage: 5.14, expected: VccOk},
+		{previous: VccOver, voltage: 4.7, expected: VccUnder},
+		{previous: VccError, voltage: 4.8, expected: VccOk},
+	}
+
+	for _, tt := range tests {
+		if state := channel.Evaluate(tt.previous, tt.voltage); state != tt.expected {
+			t.Errorf("Expected: %v for: %vV after: %v got: %v", tt.expected, tt.voltage, tt.previous, state)
+		}
+	}
+
+	// Without a maximum there's no upper limit
+	if state := (VccChannel{Min: 3}).Evaluate(VccOk, 100); state != VccOk {
+		t.Errorf("Expected: %v without maximum got: %v", VccOk, state)
+	}
+}
+
+func TestReadVccChannel(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "rm-monitor")
+
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(root)
+
+	writeSysfsFiles(t, root, map[string]string{
+		"class/hwmon/hwmon0/name":                        "cpu_thermal\n",
+		"class/hwmon/hwmon1/name":                        "ina219\n",
+		"class/hwmon/hwmon1/in1_input":                   "4980\n",
+		"bus/iio/devices/iio:device0/name":               "2198000.adc\n",
+		"bus/iio/devices/iio:device0/in_voltage0_raw":    "2048\n",
+		"bus/iio/devices/iio:device0/in_voltage_scale":   "0.8056640625\n",
+		"bus/iio/devices/iio:device0/in_voltage1_raw":    "1000\n",
+		"bus/iio/devices/iio:device0/in_voltage1_scale":  "1\n",
+		"bus/iio/devices/iio:device0/in_voltage1_offset": "-100\n",
+	})
+
+	tests := []struct {
+		channel  VccChannel
+		expected float64
+	}{
+		{channel: VccChannel{Hwmon: "ina219", Input: "in1"}, expected: 4.98},
+		{channel: VccChannel{Hwmon: "hwmon1", Input: "in1"}, expected: 4.98},
+		{channel: VccChannel{IIO: "2198000.adc", Input: "in_voltage0"}, expected: 1.65},
+		{channel: VccChannel{IIO: "iio:device0", Input: "in_voltage0", Scale: 11}, expected: 18.15},
+		{channel: VccChannel{IIO: "2198000.adc", Input: "in_voltage1"}, expected: 0.9},
+	}
+
+	for _, tt := range tests {
+
+		voltage, err := readVccChannel(root, tt.channel)
+
+		if err != nil {
+			t.Errorf("Got unexpected error for: %+v %v", tt.channel, err)
+			continue
+		}
+
+		if math.Abs(voltage-tt.expected) > 0.001 {
+			t.Errorf("Expected: %vV for: %+v got: %vV", tt.expected, tt.channel, voltage)
+		}
+	}
+
+	for _, channel := range []VccChannel{{Hwmon: "missing", Input: "in1"}, {Hwmon: "ina219", Input: "in2"}} {
+		if _, err := readVccChannel(root, channel); err == nil {
+			t.Errorf("Expected an error for: %+v", channel)
+		}
+	}
+}
+
+func TestVccMonitorMeasure(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "rm-monitor")
+
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(root)
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	monitor := NewVccMonitor(logger, NewConfigurationStore("", DefaultConfiguration()))
+	monitor.root = root
+
+	channels := []VccChannel{
+		{Name: "5v", Hwmon: "ina219", Input: "in1", Min: 4.75, Max: 5.25, Hysteresis: 0.1},
+		{Name: "missing", Hwmon: "missing", Input: "in1", Min: 3},
+	}
+
+	writeSysfsFiles(t, root, map[string]string{"class/hwmon/hwmon0/name": "ina219", "class/hwmon/hwmon0/in1_input": "4700"})
+
+	if vccMessage := monitor.measure(channels[:1]); vccMessage.Ok || vccMessage.Channels[0].State != VccUnder {
+		t.Errorf("Expected an under voltage got: %+v", vccMessage)
+	}
+
+	// Within range but not within the hysteresis
+	writeSysfsFiles(t, root, map[string]string{"class/hwmon/hwmon0/in1_input": "4800"})
+
+	if vccMessage := monitor.measure(channels[:1]); vccMessage.Ok {
+		t.Errorf("Expected the under voltage to remain within the hysteresis got: %+v", vccMessage)
+	}
+
+	writeSysfsFiles(t, root, map[string]string{"class/hwmon/hwmon0/in1_input": "5000"})
+
+	if vccMessage := monitor.measure(channels[:1]); !vccMessage.Ok {
+		t.Errorf("Expected the voltage to be ok got: %+v", vccMessage)
+	}
+
+	// A channel which cannot be read fails the vcc status
+	if vccMessage := monitor.measure(channels); vccMessage.Ok || vccMessage.Channels[1].State != VccError {
+		t.Errorf("Expected the missing channel to fail got: %+v", vccMessage)
+	}
+
+	// Without channels there's nothing to fail
+	if vccMessage := monitor.measure(nil); !vccMessage.Ok {
+		t.Errorf("Expected ok without channels got: %+v", vccMessage)
+	}
+}
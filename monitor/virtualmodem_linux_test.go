@@ -0,0 +1,285 @@
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// virtualResponse is the response of the virtual modem to a command
+type virtualResponse struct {
+	// Lines are written as "\r\n<line>\r\n", the last line should be a final result code
+	Lines []string
+	// Delay before the response is written
+	Delay time.Duration
+	// Raw is written before the lines, for example garbage or a partial line
+	Raw string
+	// Disconnect closes the modem instead of responding
+	Disconnect bool
+}
+
+// virtualModem emulates a modem on a pty, Name is the port to open through OpenPort
+type virtualModem struct {
+	Name string
+
+	master *os.File
+	done   chan struct{}
+
+	mutex     sync.Mutex
+	responses map[string]virtualResponse
+	echo      bool
+	commands  []string
+	// copsFormat is selected with AT+COPS=3,<format>
+	copsFormat string
+}
+
+// virtualOk responds with OK only
+var virtualOk = virtualResponse{Lines: []string{"OK"}}
+
+// virtualModemResponses is a healthy modem with a sim and a 3g connection on the home network
+func virtualModemResponses() map[string]virtualResponse {
+
+	return map[string]virtualResponse{
+		"AT":         virtualOk,
+		"AT+CMEE=2":  virtualOk,
+		"AT+CPIN?":   {Lines: []string{"+CPIN: READY", "", "OK"}},
+		"AT+CSQ":     {Lines: []string{"+CSQ: 18,99", "", "OK"}},
+		"AT+CNSMOD?": {Lines: []string{"+CNSMOD: 0,4", "", "OK"}},
+		"AT+CCID":    {Lines: []string{"+CCID: \"8931087616027213997F\"", "", "OK"}},
+		"AT+CREG?":   {Lines: []string{"+CREG: 0,1", "", "OK"}},
+		"AT+CGREG?":  {Lines: []string{"+CGREG: 0,1", "", "OK"}},
+		"AT+CEREG?":  {Lines: []string{"ERROR"}},
+		"AT+COPS? 0": {Lines: []string{"+COPS: 0,0,\"KPN NL\",2", "", "OK"}},
+		"AT+COPS? 2": {Lines: []string{"+COPS: 0,2,\"20408\",2", "", "OK"}},
+	}
+}
+
+// newVirtualModem opens a pty and answers the commands with the responses, a command without response
+// gets ERROR. ATE0 and ATE1 switch the echo, which is on by default like on a real modem. Without a response
+// for AT+COPS? the response for "AT+COPS? <format>" of the format selected with AT+COPS=3,<format> is used.
+func newVirtualModem(t *testing.T, responses map[string]virtualResponse) *virtualModem {
+
+	master, name, err := openPty()
+
+	if err != nil {
+		t.Skipf("Cannot open a pty: %v", err)
+	}
+
+	modem := &virtualModem{Name: name, master: master, done: make(chan struct{}), responses: responses, echo: true, copsFormat: "0"}
+
+	go modem.serve()
+
+	return modem
+}
+
+// openPty opens the master of a new pty and returns the name of the slave
+func openPty() (*os.File, string, error) {
+
+	// Non blocking so closing the master aborts a read, the ioctls are done on the raw fd because Fd() would
+	// switch the file back to blocking mode
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	unlock := int32(0)
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCSPTLCK), uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		unix.Close(fd)
+		return nil, "", errno
+	}
+
+	number := uint32(0)
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCGPTN), uintptr(unsafe.Pointer(&number))); errno != 0 {
+		unix.Close(fd)
+		return nil, "", errno
+	}
+
+	return os.NewFile(uintptr(fd), "/dev/ptmx"), fmt.Sprintf("/dev/pts/%d", number), nil
+}
+
+func (modem *virtualModem) serve() {
+
+	defer close(modem.done)
+
+	reader := bufio.NewReader(modem.master)
+
+	for {
+
+		command, err := reader.ReadString('\r')
+
+		if err != nil {
+			return
+		}
+
+		command = strings.TrimSpace(command)
+
+		if command == "" {
+			continue
+		}
+
+		modem.mutex.Lock()
+
+		modem.commands = append(modem.commands, command)
+		echo := modem.echo
+		response, ok := modem.responses[command]
+
+		if !ok && command == "AT+COPS?" {
+			response, ok = modem.responses[command+" "+modem.copsFormat]
+		}
+
+		switch {
+		case ok:
+		case command == "ATE0" || command == "ATE1":
+			modem.echo = command == "ATE1"
+			response = virtualOk
+		case strings.HasPrefix(command, "AT+COPS=3,"):
+			modem.copsFormat = strings.TrimPrefix(command, "AT+COPS=3,")
+			response = virtualOk
+		default:
+			response = virtualResponse{Lines: []string{"ERROR"}}
+		}
+
+		modem.mutex.Unlock()
+
+		time.Sleep(response.Delay)
+
+		if response.Disconnect {
+			modem.master.Close()
+			return
+		}
+
+		output := response.Raw
+
+		if echo {
+			output = command + "\r" + output
+		}
+
+		for _, line := range response.Lines {
+			if line != "" {
+				output += "\r\n" + line + "\r\n"
+			}
+		}
+
+		if _, err := modem.master.Write([]byte(output)); err != nil {
+			return
+		}
+	}
+}
+
+// Respond replaces the response to the command
+func (modem *virtualModem) Respond(command string, response virtualResponse) {
+
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+
+	modem.responses[command] = response
+}
+
+// Inject writes unsolicited result codes
+func (modem *virtualModem) Inject(t *testing.T, lines ...string) {
+
+	for _, line := range lines {
+		if _, err := modem.master.Write([]byte("\r\n" + line + "\r\n")); err != nil {
+			t.Fatalf("Cannot inject: %q %v", line, err)
+		}
+	}
+}
+
+// Commands returns the commands received so far
+func (modem *virtualModem) Commands() []string {
+
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+
+	return append([]string(nil), modem.commands...)
+}
+
+// Disconnect closes the modem, the port reads fail like when the usb device is removed
+func (modem *virtualModem) Disconnect() {
+	modem.master.Close()
+	<-modem.done
+}
+
+func TestVirtualModem(t *testing.T) {
+
+	logger, err := New("test", 1, ioutil.Discard)
+
+	if err != nil {
+		t.Fatalf("Cannot create logger: %v", err)
+	}
+
+	modem := newVirtualModem(t, virtualModemResponses())
+	defer modem.Disconnect()
+
+	modem.Respond("AT+SLOW", virtualResponse{Lines: []string{"OK"}, Delay: 300 * time.Millisecond})
+	modem.Respond("AT+GARBAGE", virtualResponse{Raw: "\x00\xff@#\r", Lines: []string{"+GARBAGE: 1", "OK"}})
+
+	port, err := OpenPort(&Config{Name: modem.Name, Baud: 115200})
+
+	if err != nil {
+		t.Fatalf("Cannot open the virtual modem: %v", err)
+	}
+
+	defer port.Close()
+
+	handler := NewAtCommandHandler(port, 200*time.Millisecond, logger)
+	received := make(chan string, 1)
+
+	handler.HandleURC("+CREG:", func(line string) { received <- line })
+
+	// The echo is skipped until it's switched off
+	if lines, err := ATRaw(context.Background(), handler, "AT+CSQ"); err != nil || len(lines) != 2 || lines[0] != "+CSQ: 18,99" {
+		t.Errorf("Expected the csq response got: %q %v", lines, err)
+	}
+
+	if err := ATE(context.Background(), handler, false); err != nil {
+		t.Errorf("Got unexpected error for ATE0: %v", err)
+	}
+
+	if _, err := handler.Command(context.Background(), "AT+UNKNOWN"); err == nil {
+		t.Errorf("Expected an error for an unknown command")
+	}
+
+	if _, err := handler.Command(context.Background(), "AT+SLOW"); err == nil {
+		t.Errorf("Expected a timeout for the slow command")
+	}
+
+	if response, err := handler.Command(context.Background(), "AT+GARBAGE"); err != nil || len(response.Lines) != 2 || response.Lines[1] != "+GARBAGE: 1" {
+		t.Errorf("Expected the garbage and the response got: %+v %v", response, err)
+	}
+
+	modem.Inject(t, "+CREG: 1")
+
+	select {
+	case line := <-received:
+		if line != "+CREG: 1" {
+			t.Errorf("Expected: +CREG: 1 got: %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the injected +CREG: 1")
+	}
+
+	modem.Respond("AT", virtualResponse{Disconnect: true})
+
+	if err := AT(context.Background(), handler); err == nil {
+		t.Errorf("Expected an error after the disconnect")
+	}
+
+	if commands := modem.Commands(); len(commands) != 6 || commands[1] != "ATE0" {
+		t.Errorf("Unexpected commands: %q", commands)
+	}
+}